@@ -0,0 +1,41 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/cisco-sso/kdk/pkg/kdk"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var buildForce = false
+
+var buildCmd = &cobra.Command{
+	Use:   "build",
+	Short: "Build KDK extensions image",
+	Long:  `Build an image with the configured Extensions layered on top of the KDK base image and configure KDK to use it`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := kdk.Build(&CurrentKdkEnvConfig, buildForce); err != nil {
+			log.WithField("error", err).Fatal("Failed to build KDK extensions image")
+		}
+	},
+}
+
+func init() {
+	buildCmd.Flags().BoolVarP(&buildForce, "force", "f", false, "Rebuild without cache even if the image exists")
+
+	rootCmd.AddCommand(buildCmd)
+}
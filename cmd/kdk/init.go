@@ -15,7 +15,10 @@
 package cmd
 
 import (
+	"strings"
+
 	"github.com/cisco-sso/kdk/pkg/kdk"
+	"github.com/cisco-sso/kdk/pkg/secrets"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -28,18 +31,25 @@ var initCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		CurrentKdkEnvConfig.CreateKdkConfig()
 		CurrentKdkEnvConfig.CreateKdkSshKeyPair()
+		if err := CurrentKdkEnvConfig.CreateKdkHostKey(); err != nil {
+			log.WithField("error", err).Fatal("Failed to create KDK ssh host key")
+		}
 		log.Infof("KDK config written to %s. Modify this file to suit your needs.", CurrentKdkEnvConfig.ConfigPath())
 	},
 }
 
 func init() {
 	initCmd.Flags().StringVarP(&CurrentKdkEnvConfig.ConfigFile.AppConfig.Name, "name", "n", "kdk", "KDK Name")
-	initCmd.Flags().StringVarP(&CurrentKdkEnvConfig.ConfigFile.AppConfig.Port, "port", "p", kdk.Port, "KDK Port")
+	initCmd.Flags().StringVarP(&CurrentKdkEnvConfig.ConfigFile.AppConfig.Port, "port", "p", "", "KDK Port (default first free port not assigned to another KDK)")
 	initCmd.Flags().StringVarP(&CurrentKdkEnvConfig.ConfigFile.AppConfig.ImageRepository, "image-repository", "r", "ciscosso/kdk", "KDK Image Repository")
 	initCmd.Flags().StringVarP(&CurrentKdkEnvConfig.ConfigFile.AppConfig.ImageTag, "image-tag", "t", kdk.Version, "KDK Image Tag")
 	initCmd.Flags().StringVarP(&CurrentKdkEnvConfig.ConfigFile.AppConfig.DotfilesRepo, "dotfiles-repo", "", "https://github.com/cisco-sso/yadm-dotfiles.git", "KDK Dotfiles Repo")
 	initCmd.Flags().StringVarP(&CurrentKdkEnvConfig.ConfigFile.AppConfig.Shell, "shell", "s", "/bin/bash", "KDK shell")
+	initCmd.Flags().StringVarP(&CurrentKdkEnvConfig.ConfigFile.AppConfig.BindAddress, "bind-address", "", "127.0.0.1", "Host address on which the KDK ssh port is published (0.0.0.0 shares it with other hosts)")
 	initCmd.Flags().StringVarP(&CurrentKdkEnvConfig.SocksPort, "socks-port", "D", "", "KDK SOCKS Port")
+	initCmd.Flags().StringVarP(&CurrentKdkEnvConfig.Secrets.Provider, "secrets", "", "", "Secrets filesystem to mount ("+strings.Join(secrets.Providers, "|")+"; default first detected)")
+	initCmd.Flags().StringVarP(&CurrentKdkEnvConfig.Secrets.Source, "secrets-source", "", "", "Host path of the secrets filesystem (default detected)")
+	initCmd.Flags().StringSliceVarP(&CurrentKdkEnvConfig.Secrets.Subpaths, "secrets-subpath", "", nil, "Mount only this subpath of the secrets filesystem, read-only (e.g. team/<team>); may be repeated")
 
 	rootCmd.AddCommand(initCmd)
 }
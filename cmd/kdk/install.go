@@ -0,0 +1,40 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/cisco-sso/kdk/pkg/kdk"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var installCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Install the KDK binary for the current user",
+	Long: `Install the running KDK binary into ~/.kdk/bin, or $XDG_BIN_HOME when set, so that
+kdk update and kdk upgrade replace it without sudo`,
+	Run: func(cmd *cobra.Command, args []string) {
+		result, err := kdk.Install(&CurrentKdkEnvConfig)
+		if err != nil {
+			log.WithField("error", err).Fatal("Failed to install KDK binary")
+		}
+		writeResult(result)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(installCmd)
+}
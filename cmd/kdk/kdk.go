@@ -17,6 +17,8 @@ package cmd
 import (
 	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/cisco-sso/kdk/pkg/kdk"
 	"github.com/ghodss/yaml"
@@ -28,6 +30,7 @@ import (
 var (
 	CurrentKdkEnvConfig = kdk.KdkEnvConfig{}
 	debug               = false
+	offline             = false
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -43,6 +46,10 @@ var rootCmd = &cobra.Command{
 \_|\_\\____/\_|\_\
                   
 A full kubernetes development environment in a container`,
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		// Give a background update check a moment to record its result for the next command
+		kdk.WaitForUpdateCheck(time.Second)
+	},
 }
 
 func Execute() {
@@ -53,10 +60,11 @@ func Execute() {
 
 func init() {
 	cobra.OnInitialize(initConfig)
-	CurrentKdkEnvConfig.Init()
 
 	rootCmd.PersistentFlags().StringVar(&CurrentKdkEnvConfig.ConfigFile.AppConfig.Name, "name", "kdk", "KDK name")
 	rootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "Debug Mode")
+	rootCmd.PersistentFlags().BoolVarP(&offline, "offline", "", false, "Skip the check for KDK updates (also "+kdk.NoUpdateCheckEnv+"=1)")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", kdk.OutputText, "Output format of command results ("+strings.Join(kdk.OutputFormats, "|")+"); logs go to stderr")
 }
 
 func initConfig() {
@@ -65,6 +73,10 @@ func initConfig() {
 		log.SetLevel(log.DebugLevel)
 	}
 
+	if err := kdk.SetOutputFormat(outputFormat); err != nil {
+		log.WithField("err", err).Fatal("Invalid output format")
+	}
+
 	if _, err := os.Stat(CurrentKdkEnvConfig.ConfigRootDir()); os.IsNotExist(err) {
 		err = os.Mkdir(CurrentKdkEnvConfig.ConfigRootDir(), 0700)
 		if err != nil {
@@ -95,7 +107,18 @@ func initConfig() {
 		if err != nil {
 			log.WithField("err", err).Error("Corrupted or deprecated kdk config file format")
 			log.Fatal("Please rebuild config file with `kdk init`")
-		} else {
+		}
+	}
+
+	// The docker client depends on the Runtime config
+	CurrentKdkEnvConfig.Init()
+
+	if CurrentKdkEnvConfig.ConfigFile.ContainerConfig != nil {
+		if err := CurrentKdkEnvConfig.MigrateConfig(); err != nil {
+			log.WithField("error", err).Fatal("Failed to migrate KDK config")
+		}
+		kdk.WarnIfExposed(&CurrentKdkEnvConfig)
+		if !offline {
 			kdk.WarnIfUpdateAvailable(&CurrentKdkEnvConfig)
 		}
 	}
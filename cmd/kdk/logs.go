@@ -0,0 +1,46 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"strings"
+
+	"github.com/cisco-sso/kdk/pkg/kdk"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var logsOptions = kdk.LogsOptions{}
+
+var logsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Show KDK container logs",
+	Long:  `Show logs of the KDK container, the nested dockerd, user provisioning or kdk-setup`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := kdk.Logs(CurrentKdkEnvConfig, logsOptions); err != nil {
+			log.WithField("error", err).Fatal("Failed to show KDK logs")
+		}
+	},
+}
+
+func init() {
+	logsCmd.Flags().StringVarP(&logsOptions.Source, "source", "", kdk.LogSourceContainer, "Log source ("+strings.Join(kdk.LogSources, "|")+")")
+	logsCmd.Flags().BoolVarP(&logsOptions.Follow, "follow", "f", false, "Follow log output")
+	logsCmd.Flags().StringVarP(&logsOptions.Since, "since", "", "", "Show logs since timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)")
+	logsCmd.Flags().StringVarP(&logsOptions.Tail, "tail", "", "all", "Number of lines to show from the end of the logs")
+
+	rootCmd.AddCommand(logsCmd)
+}
@@ -0,0 +1,46 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/cisco-sso/kdk/pkg/kdk"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var networkCmd = &cobra.Command{
+	Use:   "network",
+	Short: "KDK container networking",
+	Long:  `Inspect KDK container networking.  Networks, DNS servers, search domains and extra hosts are configured in the Network section of the KDK config`,
+}
+
+var networkInspectCmd = &cobra.Command{
+	Use:   "inspect",
+	Short: "Show effective KDK network settings",
+	Long:  `Show the networks, resolver config, hosts file and interfaces of the running KDK container`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := kdk.NetworkInspect(CurrentKdkEnvConfig, os.Stdout); err != nil {
+			log.WithField("error", err).Fatal("Failed to inspect KDK network")
+		}
+	},
+}
+
+func init() {
+	networkCmd.AddCommand(networkInspectCmd)
+	rootCmd.AddCommand(networkCmd)
+}
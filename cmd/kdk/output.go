@@ -0,0 +1,32 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/cisco-sso/kdk/pkg/kdk"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var outputFormat = kdk.OutputText
+
+// Writes a command result to stdout in the selected output format
+func writeResult(result interface{}) {
+	if err := kdk.WriteResult(os.Stdout, outputFormat, result); err != nil {
+		log.WithField("error", err).Fatal("Failed to write result")
+	}
+}
@@ -29,5 +29,7 @@ var provisionCmd = &cobra.Command{
 }
 
 func init() {
+	provisionCmd.Flags().BoolVarP(&CurrentKdkEnvConfig.RerunHooks, "rerun-hooks", "", false, "Re-apply provisioning hooks that have already run")
+
 	rootCmd.AddCommand(provisionCmd)
 }
@@ -16,6 +16,8 @@ package cmd
 
 import (
 	"github.com/cisco-sso/kdk/pkg/kdk"
+
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -24,7 +26,11 @@ var pruneCmd = &cobra.Command{
 	Short: "Prune unused KDK container images",
 	Long:  `Prune unused KDK container images`,
 	Run: func(cmd *cobra.Command, args []string) {
-		kdk.Prune(CurrentKdkEnvConfig)
+		result, err := kdk.Prune(CurrentKdkEnvConfig)
+		if err != nil {
+			log.WithField("error", err).Fatal("Failed to prune KDK images")
+		}
+		writeResult(result)
 	},
 }
 
@@ -16,6 +16,8 @@ package cmd
 
 import (
 	"github.com/cisco-sso/kdk/pkg/kdk"
+
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -24,10 +26,28 @@ var snapshotCmd = &cobra.Command{
 	Short: "Create a snapshot of a running KDK container",
 	Long:  `Create a snapshot of a running KDK container`,
 	Run: func(cmd *cobra.Command, args []string) {
-		kdk.Snapshot(CurrentKdkEnvConfig)
+		snapshotName, err := kdk.Snapshot(CurrentKdkEnvConfig)
+		if err != nil {
+			log.WithField("error", err).Fatal("Failed to create KDK snapshot")
+		}
+		writeResult(kdk.SnapshotResult{Image: snapshotName})
+	},
+}
+
+var snapshotListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List snapshots of the KDK container",
+	Long:  `List snapshot images of the KDK container, oldest first`,
+	Run: func(cmd *cobra.Command, args []string) {
+		result, err := kdk.SnapshotList(CurrentKdkEnvConfig)
+		if err != nil {
+			log.WithField("error", err).Fatal("Failed to list KDK snapshots")
+		}
+		writeResult(result)
 	},
 }
 
 func init() {
+	snapshotCmd.AddCommand(snapshotListCmd)
 	rootCmd.AddCommand(snapshotCmd)
 }
@@ -0,0 +1,51 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var sshConfigInstall = false
+
+var sshConfigCmd = &cobra.Command{
+	Use:   "ssh-config",
+	Short: "Generate OpenSSH config for every KDK",
+	Long: `Print OpenSSH config with a "Host kdk-<name>" entry for every KDK, for editors and tools such as rsync or ansible.
+With --install, write it to ~/.ssh/kdk.config, include it from ~/.ssh/config and keep it up to date as KDK ports and keys change.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if sshConfigInstall {
+			if err := CurrentKdkEnvConfig.InstallSSHConfig(); err != nil {
+				log.WithField("error", err).Fatal("Failed to install KDK ssh config")
+			}
+			log.Infof("KDK ssh config written to %s", CurrentKdkEnvConfig.SSHConfigPath())
+			return
+		}
+		sshConfig, err := CurrentKdkEnvConfig.SSHConfig()
+		if err != nil {
+			log.WithField("error", err).Fatal("Failed to generate KDK ssh config")
+		}
+		fmt.Print(sshConfig)
+	},
+}
+
+func init() {
+	sshConfigCmd.Flags().BoolVarP(&sshConfigInstall, "install", "", false, "Write ~/.ssh/kdk.config and include it from ~/.ssh/config")
+
+	rootCmd.AddCommand(sshConfigCmd)
+}
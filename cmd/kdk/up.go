@@ -24,7 +24,7 @@ var upCmd = &cobra.Command{
 	Short: "Start KDK container",
 	Long:  `Start KDK container`,
 	Run: func(cmd *cobra.Command, args []string) {
-		kdk.Up(CurrentKdkEnvConfig)
+		kdk.Up(&CurrentKdkEnvConfig)
 		kdk.Provision(CurrentKdkEnvConfig)
 	},
 }
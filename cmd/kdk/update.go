@@ -15,19 +15,26 @@
 package cmd
 
 import (
+	"strings"
+
 	"github.com/cisco-sso/kdk/pkg/kdk"
 	"github.com/spf13/cobra"
 )
 
+var updateOptions = kdk.UpdateOptions{}
+
 var updateCmd = &cobra.Command{
 	Use:   "update",
 	Short: "Update KDK image and binary",
-	Long:  `Update KDK image and binary`,
+	Long:  `Update KDK image and binary to the latest release, or to a specific release, or roll back the last update`,
 	Run: func(cmd *cobra.Command, args []string) {
-		kdk.Update(&CurrentKdkEnvConfig)
+		writeResult(kdk.Update(&CurrentKdkEnvConfig, updateOptions))
 	},
 }
 
 func init() {
+	updateCmd.Flags().StringVarP(&updateOptions.Version, "version", "", "", "Release to install (e.g. v1.2.3) instead of the latest release")
+	updateCmd.Flags().StringVarP(&updateOptions.Channel, "channel", "", "", "Release channel to follow from now on ("+strings.Join(kdk.UpdateChannels, "|")+")")
+	updateCmd.Flags().BoolVarP(&updateOptions.Rollback, "rollback", "", false, "Restore the binary, image tag and config in place before the last update")
 	rootCmd.AddCommand(updateCmd)
 }
@@ -0,0 +1,47 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/cisco-sso/kdk/pkg/kdk"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var upgradeOptions = kdk.UpgradeOptions{}
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Upgrade the KDK binary, image, config and container",
+	Long: `Upgrade the KDK binary, pull the new image, and replace the KDK container with
+one created from the upgraded config.  The user home directory is carried over
+into the new container, and the previous container is restored if any step fails.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		result, err := kdk.Upgrade(&CurrentKdkEnvConfig, upgradeOptions)
+		writeResult(result)
+		if err != nil {
+			log.WithField("error", err).Fatal("Failed to upgrade KDK")
+		}
+	},
+}
+
+func init() {
+	upgradeCmd.Flags().StringVarP(&upgradeOptions.Version, "version", "", "", "Release to upgrade to (e.g. v1.2.3) instead of the latest release")
+	upgradeCmd.Flags().BoolVarP(&upgradeOptions.Snapshot, "snapshot", "", false, "Snapshot the KDK container before replacing it")
+	upgradeCmd.Flags().BoolVarP(&upgradeOptions.PreserveHome, "preserve-home", "", true, "Copy the user home directory into the new KDK container")
+
+	rootCmd.AddCommand(upgradeCmd)
+}
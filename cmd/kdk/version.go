@@ -25,7 +25,11 @@ var versionCmd = &cobra.Command{
 	Short: "Print version information.",
 	Long:  `Print version information.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		log.WithFields(log.Fields{"command": "version", "version": kdk.Version}).Info("kdk")
+		if outputFormat == kdk.OutputText {
+			log.WithFields(log.Fields{"command": "version", "version": kdk.Version}).Info("kdk")
+			return
+		}
+		writeResult(kdk.NewVersionResult())
 	},
 }
 
@@ -55,8 +55,14 @@ set -euo pipefail
 sed '/^search $/d' /etc/resolv.conf > /tmp/resolv.conf
 cat /tmp/resolv.conf | tee /etc/resolv.conf && rm -f /tmp/resolv.conf
 
+# The name at which the container reaches the host depends on the container
+#   engine (docker, podman, colima).  kdk passes it in the container
+#   environment, which systemd services do not inherit, so read it from pid 1.
+KDK_HOST_GATEWAY=$(tr '\0' '\n' < /proc/1/environ | sed -n 's/^KDK_HOST_GATEWAY=//p')
+KDK_HOST_GATEWAY=${KDK_HOST_GATEWAY:-host.docker.internal}
+
 # Find the host IP from the perspective of *this* container
-export HOST_ACCESS_IP=$(host host.docker.internal | grep "has address" | cut -d' ' -f 4)
+export HOST_ACCESS_IP=$(host ${KDK_HOST_GATEWAY} | grep "has address" | cut -d' ' -f 4)
 # List of wildcard domains, space separated
 export DOMAINS="kdk kube docker docker-for-desktop docker-for-desktop.example.org"
 
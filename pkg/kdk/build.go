@@ -0,0 +1,212 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/cli/cli/command"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+)
+
+// Team-specific customizations layered on top of the KDK base image
+type Extensions struct {
+	Packages   []string `json:",omitempty"` // OS packages installed with apt-get
+	Scripts    []string `json:",omitempty"` // host scripts copied into the build context and run as root
+	Dockerfile string   `json:",omitempty"` // raw Dockerfile instructions appended last
+}
+
+// Directory within the build context (and image) that holds extension scripts
+const extensionsScriptDir = "kdk-extensions"
+
+// check if the config declares any extensions
+func (c *KdkEnvConfig) HasExtensions() bool {
+	ext := c.ConfigFile.AppConfig.Extensions
+	return ext != nil && (len(ext.Packages) > 0 || len(ext.Scripts) > 0 || ext.Dockerfile != "")
+}
+
+// Pulls the KDK image and builds its extensions, unless the container runs a
+// snapshot, which exists only locally and already contains the extensions
+func prepareImage(c *KdkEnvConfig) error {
+	if isSnapshotImage(*c, c.ConfigFile.ContainerConfig.Image) {
+		log.WithField("image", c.ConfigFile.ContainerConfig.Image).Debug("Not pulling or building KDK snapshot image")
+		return nil
+	}
+	Pull(c, false)
+	if c.HasExtensions() {
+		return Build(c, false)
+	}
+	return nil
+}
+
+// kdk image coordinates the container should run: the extensions image when
+// extensions are configured, otherwise the base image
+func (c *KdkEnvConfig) ContainerImageCoordinates() (out string) {
+	if !c.HasExtensions() {
+		return c.ImageCoordinates()
+	}
+	_, coordinates, err := extensionsBuildContext(c)
+	if err != nil {
+		log.WithField("error", err).Warn("Failed to compute KDK extensions image coordinates")
+		return c.ImageCoordinates()
+	}
+	return coordinates
+}
+
+// Build the extensions image and point the config at it.  The image is tagged
+// <repo>:<tag>-<hash>, where the hash covers the base image and all extension
+// content, so a build is skipped when an identical image already exists.
+func Build(cfg *KdkEnvConfig, force bool) error {
+	if !cfg.HasExtensions() {
+		log.Info("No KDK extensions configured. Nothing to build...")
+		return nil
+	}
+
+	buildContext, coordinates, err := extensionsBuildContext(cfg)
+	if err != nil {
+		return err
+	}
+	tag := coordinates[strings.LastIndex(coordinates, ":")+1:]
+
+	if !force && hasKdkImageWithTag(cfg, tag) {
+		log.WithField("image", coordinates).Info("KDK extensions image is up to date")
+	} else {
+		// The base image must be present locally to build FROM it
+		if err := Pull(cfg, false); err != nil {
+			return err
+		}
+
+		log.WithField("image", coordinates).Info("Building KDK extensions image. This may take a moment...")
+		resp, err := cfg.DockerClient.ImageBuild(cfg.Ctx, bytes.NewReader(buildContext), types.ImageBuildOptions{
+			Tags:        []string{coordinates},
+			Remove:      true,
+			ForceRemove: true,
+			NoCache:     force,
+			Labels:      map[string]string{"kdk-base": cfg.ImageCoordinates()},
+		})
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		outStream := command.NewOutStream(HumanOutput)
+		if err := jsonmessage.DisplayJSONMessagesToStream(resp.Body, outStream, nil); err != nil {
+			return err
+		}
+		log.WithField("image", coordinates).Info("Successfully built KDK extensions image")
+	}
+
+	if cfg.ConfigFile.ContainerConfig != nil && cfg.ConfigFile.ContainerConfig.Image != coordinates {
+		cfg.ConfigFile.ContainerConfig.Image = coordinates
+		if err := cfg.WriteConfig(); err != nil {
+			return err
+		}
+		log.Info("KDK config updated to use extensions image.  Recreate the KDK container to use it.")
+	}
+	return nil
+}
+
+// Generate the Dockerfile for the configured extensions
+func extensionsDockerfile(cfg *KdkEnvConfig, scripts []string) string {
+	ext := cfg.ConfigFile.AppConfig.Extensions
+
+	lines := []string{
+		"FROM " + cfg.ImageCoordinates(),
+		"USER root",
+	}
+	if len(ext.Packages) > 0 {
+		lines = append(lines, "RUN apt-get update && \\",
+			"    DEBIAN_FRONTEND=noninteractive apt-get install -y --no-install-recommends "+strings.Join(ext.Packages, " ")+" && \\",
+			"    rm -rf /var/lib/apt/lists/*")
+	}
+	if len(scripts) > 0 {
+		lines = append(lines, "COPY "+extensionsScriptDir+"/ /tmp/"+extensionsScriptDir+"/")
+		for _, script := range scripts {
+			lines = append(lines, "RUN /tmp/"+extensionsScriptDir+"/"+script)
+		}
+		lines = append(lines, "RUN rm -rf /tmp/"+extensionsScriptDir)
+	}
+	if ext.Dockerfile != "" {
+		lines = append(lines, ext.Dockerfile)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Create the tar build context for the extensions image along with the image
+// coordinates derived from its content
+func extensionsBuildContext(cfg *KdkEnvConfig) (buildContext []byte, coordinates string, err error) {
+	ext := cfg.ConfigFile.AppConfig.Extensions
+
+	var scripts []string
+	files := map[string][]byte{}
+	for i, script := range ext.Scripts {
+		path, err := homedir.Expand(script)
+		if err != nil {
+			return nil, "", err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read extension script [%s]: %v", script, err)
+		}
+		// Prefix with the index so that scripts run in configured order and basenames may repeat
+		name := fmt.Sprintf("%02d-%s", i, filepath.Base(path))
+		scripts = append(scripts, name)
+		files[extensionsScriptDir+"/"+name] = content
+	}
+	dockerfile := extensionsDockerfile(cfg, scripts)
+
+	hash := sha256.New()
+	hash.Write([]byte(dockerfile))
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := addTarFile(tw, "Dockerfile", []byte(dockerfile), 0644); err != nil {
+		return nil, "", err
+	}
+	for _, name := range scripts {
+		path := extensionsScriptDir + "/" + name
+		hash.Write([]byte(path))
+		hash.Write(files[path])
+		if err := addTarFile(tw, path, files[path], 0755); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, "", err
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))[:12]
+	coordinates = cfg.ConfigFile.AppConfig.ImageRepository + ":" + cfg.ConfigFile.AppConfig.ImageTag + "-" + sum
+	return buf.Bytes(), coordinates, nil
+}
+
+// Add a single regular file to a tar archive
+func addTarFile(tw *tar.Writer, name string, content []byte, mode int64) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: mode, Size: int64(len(content))}); err != nil {
+		return err
+	}
+	_, err := tw.Write(content)
+	return err
+}
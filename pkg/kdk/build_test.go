@@ -0,0 +1,186 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func extensionsConfig(ext *Extensions) *KdkEnvConfig {
+	cfg := &KdkEnvConfig{}
+	cfg.ConfigFile.AppConfig.ImageRepository = "ciscosso/kdk"
+	cfg.ConfigFile.AppConfig.ImageTag = "1.0.0"
+	cfg.ConfigFile.AppConfig.Extensions = ext
+	return cfg
+}
+
+func TestExtensionsDockerfile(t *testing.T) {
+
+	cfg := extensionsConfig(&Extensions{
+		Packages:   []string{"jq", "postgresql-client"},
+		Dockerfile: "ENV TEAM=sso",
+	})
+	expected := strings.Join([]string{
+		"FROM ciscosso/kdk:1.0.0",
+		"USER root",
+		"RUN apt-get update && \\",
+		"    DEBIAN_FRONTEND=noninteractive apt-get install -y --no-install-recommends jq postgresql-client && \\",
+		"    rm -rf /var/lib/apt/lists/*",
+		"COPY kdk-extensions/ /tmp/kdk-extensions/",
+		"RUN /tmp/kdk-extensions/00-a.sh",
+		"RUN /tmp/kdk-extensions/01-a.sh",
+		"RUN rm -rf /tmp/kdk-extensions",
+		"ENV TEAM=sso",
+	}, "\n") + "\n"
+	if actual := extensionsDockerfile(cfg, []string{"00-a.sh", "01-a.sh"}); actual != expected {
+		t.Logf("Dockerfile is:\n%s\nexpected:\n%s", actual, expected)
+		t.FailNow()
+	}
+
+	cfg = extensionsConfig(&Extensions{Dockerfile: "RUN true"})
+	if actual := extensionsDockerfile(cfg, nil); actual != "FROM ciscosso/kdk:1.0.0\nUSER root\nRUN true\n" {
+		t.Logf("Dockerfile without packages or scripts is:\n%s", actual)
+		t.FailNow()
+	}
+}
+
+func TestExtensionsBuildContext(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "kdk-extensions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Scripts with the same basename in different directories
+	for _, sub := range []string{"one", "two"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, sub, "setup.sh"), []byte("#!/bin/sh\necho "+sub+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := extensionsConfig(&Extensions{Scripts: []string{filepath.Join(dir, "one", "setup.sh"), filepath.Join(dir, "two", "setup.sh")}})
+
+	buildContext, coordinates, err := extensionsBuildContext(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^ciscosso/kdk:1\.0\.0-[0-9a-f]{12}$`).MatchString(coordinates) {
+		t.Logf("Extensions image coordinates are %s, expected ciscosso/kdk:1.0.0-<hash>.", coordinates)
+		t.FailNow()
+	}
+
+	files := map[string]string{}
+	tr := tar.NewReader(bytes.NewReader(buildContext))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(tr)
+		files[header.Name] = string(content)
+	}
+	expected := map[string]string{
+		"Dockerfile":                 extensionsDockerfile(cfg, []string{"00-setup.sh", "01-setup.sh"}),
+		"kdk-extensions/00-setup.sh": "#!/bin/sh\necho one\n",
+		"kdk-extensions/01-setup.sh": "#!/bin/sh\necho two\n",
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Logf("Build context is %v, expected %v.", files, expected)
+		t.FailNow()
+	}
+
+	cfg.ConfigFile.AppConfig.Extensions.Scripts = append(cfg.ConfigFile.AppConfig.Extensions.Scripts, filepath.Join(dir, "missing.sh"))
+	if _, _, err := extensionsBuildContext(cfg); err == nil {
+		t.Log("Missing extension script is accepted.")
+		t.FailNow()
+	}
+}
+
+func TestExtensionsImageTagHash(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "kdk-extensions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "setup.sh")
+	if err := ioutil.WriteFile(script, []byte("echo one\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := extensionsConfig(&Extensions{Packages: []string{"jq"}, Scripts: []string{script}})
+	coordinates := func() string {
+		_, coordinates, err := extensionsBuildContext(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return coordinates
+	}
+
+	original := coordinates()
+	if coordinates() != original {
+		t.Log("Extensions image tag is not stable.")
+		t.FailNow()
+	}
+	cfg.ConfigFile.AppConfig.ImageTag = "1.1.0"
+	if actual := coordinates(); !strings.HasPrefix(actual, "ciscosso/kdk:1.1.0-") || actual[len(actual)-12:] == original[len(original)-12:] {
+		t.Logf("Extensions image %s does not follow the base image tag change from %s.", actual, original)
+		t.FailNow()
+	}
+	cfg.ConfigFile.AppConfig.ImageTag = "1.0.0"
+	if err := ioutil.WriteFile(script, []byte("echo two\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if coordinates() == original {
+		t.Log("Extensions image tag does not change with script content.")
+		t.FailNow()
+	}
+	cfg.ConfigFile.AppConfig.Extensions.Packages = []string{"jq", "git"}
+	if coordinates() == original {
+		t.Log("Extensions image tag does not change with packages.")
+		t.FailNow()
+	}
+}
+
+func TestSetConfigVersionKeepsExtensionsImage(t *testing.T) {
+
+	cfg := extensionsConfig(&Extensions{Packages: []string{"jq"}})
+	cfg.ConfigFile.ContainerConfig = &container.Config{Labels: map[string]string{"kdk": "1.0.0"}}
+
+	setConfigVersion(cfg, "1.1.0")
+	if !strings.HasPrefix(cfg.ConfigFile.ContainerConfig.Image, "ciscosso/kdk:1.1.0-") {
+		t.Logf("Config image is %s, expected the 1.1.0 extensions image.", cfg.ConfigFile.ContainerConfig.Image)
+		t.FailNow()
+	}
+	if needsUpdateConfig(cfg, "1.1.0") {
+		t.Log("Config still needs an update after being updated.")
+		t.FailNow()
+	}
+}
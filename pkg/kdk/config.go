@@ -18,18 +18,17 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/user"
 	"path/filepath"
-	"strconv"
 	"strings"
 
-	"github.com/cisco-sso/kdk/pkg/keybase"
 	"github.com/cisco-sso/kdk/pkg/prompt"
+	"github.com/cisco-sso/kdk/pkg/secrets"
 	"github.com/cisco-sso/kdk/pkg/ssh"
-	"github.com/cisco-sso/kdk/pkg/utils"
 	"github.com/codeskyblue/go-sh"
-	"github.com/docker/docker/api/types"
+	"github.com/docker/cli/cli/connhelper"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
@@ -41,14 +40,17 @@ import (
 
 var (
 	Version = "undefined"
-	Port    = strconv.Itoa(utils.GetPort())
 )
 
 type KdkEnvConfig struct {
-	DockerClient *client.Client
+	DockerClient client.APIClient
 	Ctx          context.Context
 	ConfigFile   configFile
 	SocksPort    string
+	RerunHooks   bool
+	Secrets      secrets.Config // secrets provider chosen on the command line
+
+	engine string // container engine resolved by Init
 }
 
 // Struct of all configs to be saved directly as ~/.kdk/<NAME>/config.yaml
@@ -66,17 +68,55 @@ type AppConfig struct {
 	DotfilesRepo    string
 	Shell           string
 	SocksPort       string
+	Extensions      *Extensions     `json:",omitempty"`
+	Hooks           *Hooks          `json:",omitempty"`
+	ReadyTimeout    string          `json:",omitempty"`
+	Runtime         *Runtime        `json:",omitempty"`
+	Host            *Host           `json:",omitempty"`
+	BindAddress     string          `json:",omitempty"`
+	Network         *Network        `json:",omitempty"`
+	Proxy           *Proxy          `json:",omitempty"`
+	Trust           *Trust          `json:",omitempty"`
+	Update          *UpdateSettings `json:",omitempty"`
+	Secrets         *secrets.Config `json:",omitempty"`
 }
 
-// create docker client and context for easy reuse
+// Container path at which the ssh public key is provided for provision-user
+const publicKeyContainerPath = "/tmp/id_rsa.pub"
+
+// create docker client and context for easy reuse.  The client connects to
+// the configured or auto-discovered engine, so call this after loading config.
 func (c *KdkEnvConfig) Init() {
 	c.Ctx = context.Background()
-	dockerClient, err := client.NewEnvClient()
+
+	engine, host, err := resolveEngine(c.runtimeConfig())
 	if err != nil {
-		log.Warn("Failed to create docker client.")
-		log.Fatal("Ensure that docker is running.")
+		log.WithField("error", err).Fatal("Failed to resolve container engine.")
+	}
+	c.engine = engine
+
+	opts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if host != "" {
+		opts = append(opts, client.WithHost(host))
 	}
 
+	// Remote engines: the docker client cannot dial ssh:// itself, so tunnel through the ssh command
+	if dockerHost := c.DockerHost(); strings.HasPrefix(dockerHost, "ssh://") {
+		helper, err := connhelper.GetConnectionHelper(dockerHost)
+		if err != nil {
+			log.WithField("error", err).Fatalf("Invalid docker host [%s]", dockerHost)
+		}
+		opts = append(opts, client.WithHost(helper.Host), client.WithDialContext(helper.Dialer))
+	} else if dockerHost := c.hostConfig().DockerHost; dockerHost != "" {
+		opts = append(opts, client.WithHost(dockerHost))
+	}
+	dockerClient, err := client.NewClientWithOpts(opts...)
+	if err != nil {
+		log.WithField("error", err).Warn("Failed to create docker client.")
+		log.Fatalf("Ensure that %s is running.", engine)
+	}
+	log.WithFields(log.Fields{"engine": engine, "host": dockerClient.DaemonHost()}).Debug("Using container engine")
+
 	c.DockerClient = dockerClient
 }
 
@@ -133,6 +173,11 @@ func (c *KdkEnvConfig) ConfigPath() (out string) {
 	return filepath.Join(c.ConfigDir(), "config.yaml")
 }
 
+// kdk container provisioning log path (~/.kdk/<KDK_NAME>/provision.log)
+func (c *KdkEnvConfig) ProvisionLogPath() (out string) {
+	return filepath.Join(c.ConfigDir(), "provision.log")
+}
+
 // kdk image coordinates (ciscosso/kdk:debian-latest)
 func (c *KdkEnvConfig) ImageCoordinates() (out string) {
 	return c.ConfigFile.AppConfig.ImageRepository + ":" + c.ConfigFile.AppConfig.ImageTag
@@ -148,23 +193,38 @@ func (c *KdkEnvConfig) CreateKdkConfig() (err error) {
 	// Define mount configurations for mounting the ssh pub key into a tmp location where the bootstrap script may
 	//   copy into <userdir>/.ssh/authorized keys.  This is required because Windows mounts squash permissions to
 	//   777 which makes ssh fail a strict check on pubkey permissions.
+	//   On remote engines the key is copied into the container at creation instead.
 	source := c.PublicKeyPath()
-	target := "/tmp/id_rsa.pub"
+	target := publicKeyContainerPath
 	mounts = append(mounts, mount.Mount{Type: mount.TypeBind, Source: source, Target: target, ReadOnly: true})
 	volumes[target] = struct{}{}
 
-	// Keybase mounts
-	source, target, err = keybase.GetMounts(c.ConfigRootDir())
-	if err != nil {
-		log.Warn("Failed to add keybase mount:", err)
-	} else {
-		mounts = append(mounts, mount.Mount{Type: mount.TypeBind, Source: source, Target: target,
-			ReadOnly: false, Consistency: mount.ConsistencyCached})
-		volumes[target] = struct{}{}
+	// Host directories cannot be bind mounted into a container on a remote engine
+	remote := c.IsRemoteEngine()
+	if remote {
+		log.Infof("Skipping host directory mounts for remote docker host [%s].  Use named volumes instead.", c.DockerHost())
+	}
+
+	// Secrets filesystem mount (keybase, encrypted directory, password store or host path)
+	if !remote {
+		secretsConfig := c.ConfigFile.AppConfig.Secrets
+		if c.Secrets.Provider != "" || len(c.Secrets.Subpaths) > 0 {
+			secretsConfig = &c.Secrets
+		}
+		secretsConfig, secretsMounts, err := secrets.Configure(c.ConfigRootDir(), secretsConfig)
+		if err != nil {
+			log.Warn("Failed to add secrets mount:", err)
+		}
+		for _, secretsMount := range secretsMounts {
+			mounts = append(mounts, mount.Mount{Type: mount.TypeBind, Source: secretsMount.Source, Target: secretsMount.Target,
+				ReadOnly: secretsMount.ReadOnly, Consistency: mount.ConsistencyCached})
+			volumes[secretsMount.Target] = struct{}{}
+		}
+		c.ConfigFile.AppConfig.Secrets = secretsConfig
 	}
 
 	// Define Additional volume bindings
-	for {
+	for !remote {
 		prmpt := prompt.Prompt{
 			Text:     "Would you like to mount additional docker host directories into the KDK? [y/n] ",
 			Loop:     true,
@@ -209,19 +269,53 @@ func (c *KdkEnvConfig) CreateKdkConfig() (err error) {
 		}
 		if result, err := prmpt.Run(); err == nil && result == "y" {
 			prmpt = prompt.Prompt{
-				Text:     "Please enter SOCKS port number [8000] ",
+				Text:     "Please enter SOCKS port number [first free from 8000] ",
 				Loop:     true,
 				Validate: prompt.ValidateIntOrEmptyString,
 			}
 			socksPort, _ = prmpt.Run()
 		}
 		if socksPort == "" {
-			socksPort = "8000"
+			if socksPort, err = allocateSocksPort(c); err != nil {
+				log.WithField("error", err).Fatal("Failed to allocate SOCKS port")
+				return err
+			}
 		}
 		c.ConfigFile.AppConfig.SocksPort = socksPort
 	} else {
 		c.ConfigFile.AppConfig.SocksPort = c.SocksPort
 	}
+
+	// The ssh port is published on loopback unless another address is deliberately chosen
+	if c.ConfigFile.AppConfig.BindAddress == "" {
+		c.ConfigFile.AppConfig.BindAddress = defaultBindAddress
+	}
+	if net.ParseIP(c.ConfigFile.AppConfig.BindAddress) == nil {
+		err := fmt.Errorf("invalid bind address [%s]", c.ConfigFile.AppConfig.BindAddress)
+		log.WithField("error", err).Fatal("Failed to create KDK config")
+		return err
+	}
+	if isAllInterfaces(c.BindAddress()) {
+		log.Warn("KDK ssh port will be reachable from other hosts on all network interfaces")
+	} else if remote && !c.sshReachableOnEngine() {
+		log.Warnf("KDK ssh port bound to %s is unreachable on remote docker host [%s]; "+
+			"set --bind-address, Host.Address or Host.JumpHost", c.BindAddress(), c.DockerHost())
+	}
+
+	// Carry the host proxy into the KDK
+	if c.ConfigFile.AppConfig.Proxy == nil {
+		if c.ConfigFile.AppConfig.Proxy = detectProxy(); c.ConfigFile.AppConfig.Proxy != nil {
+			log.Info("Detected host proxy settings (HTTP_PROXY, HTTPS_PROXY, NO_PROXY); KDK will use them")
+		}
+	}
+
+	// Allocate the ssh port if unset, and check both ports against other KDKs and host listeners.
+	//   A running KDK holds its own ports, so listeners are only checked when it is stopped.
+	if _, err := reservePorts(c, !c.IsRunning()); err != nil {
+		log.WithField("error", err).Fatal("Failed to reserve KDK ports")
+		return err
+	}
+	log.Infof("Set ssh port %v", c.ConfigFile.AppConfig.Port)
 	log.Infof("Set SOCKS port %v", c.ConfigFile.AppConfig.SocksPort)
 
 	// Create the Default configuration struct that will be written as the config file
@@ -246,6 +340,7 @@ func (c *KdkEnvConfig) CreateKdkConfig() (err error) {
 		PortBindings: nat.PortMap{
 			"2022/tcp": []nat.PortBinding{
 				{
+					HostIP:   c.BindAddress(),
 					HostPort: c.ConfigFile.AppConfig.Port,
 				},
 			},
@@ -264,7 +359,7 @@ func (c *KdkEnvConfig) CreateKdkConfig() (err error) {
 	// Ensure that the ~/.kdk/<kdkName> directory exists
 	if _, err := os.Stat(c.ConfigDir()); os.IsNotExist(err) {
 		if err := os.Mkdir(c.ConfigDir(), 0700); err != nil {
-			log.WithField("error", err).Fatalf("Failed to create KDK config directory", filepath.Dir(c.ConfigDir()))
+			log.WithField("error", err).Fatalf("Failed to create KDK config directory [%s]", c.ConfigDir())
 			return err
 		}
 	}
@@ -279,6 +374,7 @@ func (c *KdkEnvConfig) CreateKdkConfig() (err error) {
 		log.Info("Creating KDK config")
 
 		ioutil.WriteFile(c.ConfigPath(), y, 0600)
+		c.refreshSSHConfig()
 	} else {
 		log.Warn("KDK config exists")
 		prmpt := prompt.Prompt{
@@ -289,6 +385,7 @@ func (c *KdkEnvConfig) CreateKdkConfig() (err error) {
 		if result, err := prmpt.Run(); err == nil && result == "y" {
 			log.Info("Creating KDK config")
 			ioutil.WriteFile(c.ConfigPath(), y, 0600)
+			c.refreshSSHConfig()
 		} else {
 			log.Info("Existing KDK config not overwritten")
 			return err
@@ -297,6 +394,37 @@ func (c *KdkEnvConfig) CreateKdkConfig() (err error) {
 	return nil
 }
 
+// Upgrades a config written by an earlier KDK version in place, saving it if
+// anything changed
+func (c *KdkEnvConfig) MigrateConfig() error {
+	changed := false
+	if migrateBindAddress(&c.ConfigFile) {
+		log.Infof("Migrated KDK config: ssh port bound to %s", c.BindAddress())
+		changed = true
+	}
+	if migrateSecrets(&c.ConfigFile) {
+		log.Info("Migrated KDK config: keybase mount managed by the keybase secrets provider")
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return c.WriteConfig()
+}
+
+// Writes the in-memory config to ~/.kdk/<KDK_NAME>/config.yaml
+func (c *KdkEnvConfig) WriteConfig() error {
+	y, err := yaml.Marshal(c.ConfigFile)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(c.ConfigPath(), y, 0600); err != nil {
+		return err
+	}
+	c.refreshSSHConfig()
+	return nil
+}
+
 // Creates KDK ssh keypair
 func (c *KdkEnvConfig) CreateKdkSshKeyPair() (err error) {
 
@@ -341,25 +469,57 @@ func (c *KdkEnvConfig) CreateKdkSshKeyPair() (err error) {
 	return nil
 }
 
+// Returns the host name at which the KDK sshd is reachable: the configured
+// address, the host of a remote engine, or localhost (which is relative to
+// the engine host when jumping through it)
+func (c *KdkEnvConfig) SSHHost() string {
+	if address := c.hostConfig().Address; address != "" {
+		return address
+	}
+	if c.jumpsThroughEngine() {
+		return "localhost"
+	}
+	if hostname := engineHostname(c.DockerHost()); hostname != "" {
+		return hostname
+	}
+	return "localhost"
+}
+
+// Returns the host:port address at which the KDK sshd is reachable
+func (c *KdkEnvConfig) SSHAddress() string {
+	return net.JoinHostPort(c.SSHHost(), c.ConfigFile.AppConfig.Port)
+}
+
 // Returns SSH connection string
 func (c *KdkEnvConfig) SSHConnectionString() string {
-	return c.User() + "@localhost"
+	return c.User() + "@" + c.SSHHost()
 }
 
 // Returns SSH command string
 func (c *KdkEnvConfig) SSHCommandString() string {
-	return fmt.Sprintf("ssh %s -A -p %s -i %s -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null",
-		c.SSHConnectionString(), c.ConfigFile.AppConfig.Port, c.PrivateKeyPath())
+	return fmt.Sprintf("ssh %s -A -p %s -i %s %s%s",
+		c.SSHConnectionString(), c.ConfigFile.AppConfig.Port, c.PrivateKeyPath(), c.hostKeyOptions(), c.proxyJumpOption())
 }
 
 // Returns SCP command string
 func (c *KdkEnvConfig) SCPCommandString() string {
-	return fmt.Sprintf("scp -P %s -i %s -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null",
-		c.ConfigFile.AppConfig.Port, c.PrivateKeyPath())
+	return fmt.Sprintf("scp -P %s -i %s %s%s",
+		c.ConfigFile.AppConfig.Port, c.PrivateKeyPath(), c.hostKeyOptions(), c.proxyJumpOption())
+}
+
+// Returns the ssh option for the jump host, with a leading space, if one is configured
+func (c *KdkEnvConfig) proxyJumpOption() string {
+	if jumpHost := c.SSHJumpHost(); jumpHost != "" {
+		return " -o ProxyJump=" + jumpHost
+	}
+	return ""
 }
 
 // SCP's a file into the KDK container
 func (c *KdkEnvConfig) SCPTo(hostPath, kdkPath string) error {
+	if err := c.WaitReady(); err != nil {
+		return err
+	}
 	commandString := fmt.Sprintf("%s %s %s:%s", c.SCPCommandString(), hostPath, c.SSHConnectionString(), kdkPath)
 	log.Infof("executing scp command: %s", commandString)
 	commandMap := strings.Split(commandString, " ")
@@ -371,6 +531,9 @@ func (c *KdkEnvConfig) SCPTo(hostPath, kdkPath string) error {
 
 // Executes a command on the KDK container
 func (c *KdkEnvConfig) Exec(command string) error {
+	if err := c.WaitReady(); err != nil {
+		return err
+	}
 	commandString := fmt.Sprintf("%s %s", c.SSHCommandString(), command)
 	log.Infof("executing ssh command: %s", commandString)
 	commandMap := strings.Split(commandString, " ")
@@ -379,32 +542,44 @@ func (c *KdkEnvConfig) Exec(command string) error {
 
 // Checks that KDK container is running
 func (c *KdkEnvConfig) IsRunning() bool {
-	kdkRunning := false
-
-	containers, err := c.DockerClient.ContainerList(c.Ctx, types.ContainerListOptions{All: true})
+	_, state, err := containerState(*c)
 	if err != nil {
-		log.WithField("error", err).Fatal("Failed to list docker containers")
-	}
-
-	for _, container := range containers {
-		for _, name := range container.Names {
-			if name == "/"+c.ConfigFile.AppConfig.Name {
-				if container.State == "running" {
-					kdkRunning = true
-					break
-				}
-			}
-		}
+		log.WithField("error", err).Fatal("Failed to inspect KDK container")
 	}
-	return kdkRunning
+	return state == "running"
 }
 
 // If KDK container is not running, start it and provision KDK user.
 func (c *KdkEnvConfig) Start() {
-	if !c.IsRunning() {
+	_, state, err := containerState(*c)
+	if err != nil {
+		log.WithField("error", err).Fatal("Failed to inspect KDK container")
+	}
+	switch state {
+	case "running":
+	case "paused":
+		// A paused container resumes as it was, so it is neither pulled nor provisioned again
+		log.Info("KDK is currently paused.  Unpausing...")
+		Up(c)
+	default:
 		log.Info("KDK is not currently running.  Starting...")
-		Pull(c, false)
-		Up(*c)
+		if err := prepareImage(c); err != nil {
+			log.WithField("error", err).Fatal("Failed to build KDK extensions image")
+		}
+		Up(c)
+	}
+
+	// Containers and configs created before host key pinning lack the KDK host
+	// key, which provisioning needs to connect over ssh
+	err = c.CreateKdkHostKey()
+	if err == nil {
+		err = ensureHostKey(*c)
+	}
+	if err != nil {
+		log.WithField("error", err).Warn("Failed to install KDK ssh host key")
+	}
+
+	if state != "running" && state != "paused" {
 		Provision(*c)
 	}
 }
@@ -43,7 +43,7 @@ func Destroy(cfg KdkEnvConfig, force bool) error {
 		log.Info("Destroying KDK container(s)...")
 		for _, containerId := range containerIds {
 			if !force {
-				fmt.Printf("Delete KDK container [%s][%v]\n", cfg.ConfigFile.AppConfig.Name, containerId[:8])
+				fmt.Fprintf(HumanOutput, "Delete KDK container [%s][%v]\n", cfg.ConfigFile.AppConfig.Name, containerId[:8])
 				prmpt := prompt.Prompt{
 					Text:     "Continue? [y/n] ",
 					Loop:     true,
@@ -62,5 +62,8 @@ func Destroy(cfg KdkEnvConfig, force bool) error {
 	} else {
 		log.Info("No KDK containers found. Nothing to destroy...")
 	}
+	if err := ReleaseSecrets(cfg); err != nil {
+		log.WithField("error", err).Warn("Failed to release secrets filesystem")
+	}
 	return nil
 }
@@ -0,0 +1,82 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	log "github.com/sirupsen/logrus"
+)
+
+// Runs a command in the KDK container through the docker API, streaming the
+// demultiplexed stdout and stderr to the given writers.  An empty user runs
+// the command as root.  Returns the exit code of the command.
+func containerExec(cfg KdkEnvConfig, user string, command []string, stdout, stderr io.Writer) (int, error) {
+	log.WithField("command", command).Debug("Executing command in KDK container")
+
+	execResp, err := cfg.DockerClient.ContainerExecCreate(cfg.Ctx, cfg.ConfigFile.AppConfig.Name, types.ExecConfig{
+		User:         user,
+		Cmd:          command,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	attachResp, err := cfg.DockerClient.ContainerExecAttach(cfg.Ctx, execResp.ID, types.ExecStartCheck{})
+	if err != nil {
+		return -1, err
+	}
+	defer attachResp.Close()
+
+	if _, err := stdcopy.StdCopy(stdout, stderr, attachResp.Reader); err != nil {
+		return -1, err
+	}
+
+	inspectResp, err := cfg.DockerClient.ContainerExecInspect(cfg.Ctx, execResp.ID)
+	if err != nil {
+		return -1, err
+	}
+	return inspectResp.ExitCode, nil
+}
+
+// A file to be copied into the KDK container
+type containerFile struct {
+	Path    string // absolute path within the container
+	Content []byte
+	Mode    int64
+}
+
+// Copies files into a (possibly not yet started) KDK container.  Missing
+// parent directories are created owned by root.
+func copyToContainer(cfg KdkEnvConfig, containerID string, files ...containerFile) error {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, file := range files {
+		if err := addTarFile(tw, strings.TrimPrefix(file.Path, "/"), file.Content, file.Mode); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return cfg.DockerClient.CopyToContainer(cfg.Ctx, containerID, "/", &buf, types.CopyToContainerOptions{})
+}
@@ -0,0 +1,157 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+)
+
+// User-defined provisioning hooks
+type Hooks struct {
+	PostProvision PostProvisionHooks `json:",omitempty"`
+}
+
+// Hooks executed after `provision-user`; Root hooks run first as root, then
+// User hooks run as the KDK user
+type PostProvisionHooks struct {
+	Root []Hook `json:",omitempty"`
+	User []Hook `json:",omitempty"`
+}
+
+// A hook runs either a host script, which is copied into the container, or an
+// inline shell command
+type Hook struct {
+	Name    string
+	Script  string `json:",omitempty"`
+	Command string `json:",omitempty"`
+}
+
+const (
+	hookPhaseRoot = "root"
+	hookPhaseUser = "user"
+
+	// Location of hook scripts and their idempotency markers within the container
+	hooksDir = "/etc/kdk/hooks"
+)
+
+var validHookName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Runs the configured post-provision hooks, writing their output to out.  A
+// hook that already completed with identical content is skipped unless
+// RerunHooks is set.
+func runPostProvisionHooks(cfg KdkEnvConfig, out io.Writer) error {
+	hooks := cfg.ConfigFile.AppConfig.Hooks
+	if hooks == nil {
+		return nil
+	}
+	for _, hook := range hooks.PostProvision.Root {
+		if err := runHook(cfg, hookPhaseRoot, hook, out); err != nil {
+			return err
+		}
+	}
+	for _, hook := range hooks.PostProvision.User {
+		if err := runHook(cfg, hookPhaseUser, hook, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runHook(cfg KdkEnvConfig, phase string, hook Hook, out io.Writer) error {
+	if err := validateHookName(hook.Name); err != nil {
+		return err
+	}
+	content, err := hookContent(hook)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+
+	scriptPath := fmt.Sprintf("%s/%s-%s", hooksDir, phase, hook.Name)
+	markerPath := scriptPath + ".done"
+	logger := log.WithFields(log.Fields{"hook": hook.Name, "phase": phase})
+
+	// The marker records the checksum of the hook content, so changed hooks run again
+	if !cfg.RerunHooks {
+		exitCode, err := containerExec(cfg, "", []string{"sh", "-c",
+			fmt.Sprintf(`[ "$(cat %s 2>/dev/null)" = "%s" ]`, markerPath, checksum)}, ioutil.Discard, ioutil.Discard)
+		if err != nil {
+			return err
+		}
+		if exitCode == 0 {
+			logger.Info("Skipping already applied provisioning hook")
+			return nil
+		}
+	}
+
+	logger.Info("Running provisioning hook")
+	if err := copyToContainer(cfg, cfg.ConfigFile.AppConfig.Name,
+		containerFile{Path: scriptPath, Content: content, Mode: 0755}); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "==> [%s] hook %s\n", phase, hook.Name)
+	command := []string{scriptPath}
+	if phase == hookPhaseUser {
+		command = []string{"runuser", "-l", cfg.User(), "-c", scriptPath}
+	}
+	exitCode, err := containerExec(cfg, "", command, out, out)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("%s hook [%s] exited with status %d", phase, hook.Name, exitCode)
+	}
+
+	_, err = containerExec(cfg, "", []string{"sh", "-c",
+		fmt.Sprintf("echo %s > %s", checksum, markerPath)}, out, out)
+	return err
+}
+
+// Hook names become container file names, so they may not contain path separators or shell metacharacters
+func validateHookName(name string) error {
+	if !validHookName.MatchString(name) || name == "." || name == ".." {
+		return fmt.Errorf("invalid hook name [%s]: must contain only letters, digits, '.', '_' and '-'", name)
+	}
+	return nil
+}
+
+// Returns the script executed for a hook
+func hookContent(hook Hook) ([]byte, error) {
+	if hook.Script != "" && hook.Command != "" {
+		return nil, fmt.Errorf("hook [%s] must set only one of Script or Command", hook.Name)
+	}
+	if hook.Command != "" {
+		return []byte("#!/usr/bin/env bash\nset -euo pipefail\n" + hook.Command + "\n"), nil
+	}
+	if hook.Script == "" {
+		return nil, errors.New("hook [" + hook.Name + "] must set one of Script or Command")
+	}
+	path, err := homedir.Expand(hook.Script)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
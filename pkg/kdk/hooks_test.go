@@ -0,0 +1,81 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateHookName(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"install-tools", true},
+		{"step_1.sh", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../etc/passwd", false},
+		{"a/b", false},
+		{"two words", false},
+		{"x;rm -rf /", false},
+		{"$(id)", false},
+		{"`id`", false},
+		{"line\nbreak", false},
+	}
+	for _, test := range tests {
+		if err := validateHookName(test.name); (err == nil) != test.valid {
+			t.Logf("validateHookName(%q) returned %v, expected valid=%v.", test.name, err, test.valid)
+			t.FailNow()
+		}
+	}
+}
+
+func TestHookContent(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "kdk-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "hook.sh")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho hook\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		hook     Hook
+		expected string
+		valid    bool
+	}{
+		{Hook{Name: "cmd", Command: "apt-get install -y jq"}, "#!/usr/bin/env bash\nset -euo pipefail\napt-get install -y jq\n", true},
+		{Hook{Name: "script", Script: script}, "#!/bin/sh\necho hook\n", true},
+		{Hook{Name: "both", Script: script, Command: "true"}, "", false},
+		{Hook{Name: "neither"}, "", false},
+		{Hook{Name: "missing", Script: filepath.Join(dir, "missing.sh")}, "", false},
+	}
+	for _, test := range tests {
+		content, err := hookContent(test.hook)
+		if (err == nil) != test.valid || string(content) != test.expected {
+			t.Logf("hookContent(%+v) returned %q, %v; expected %q, valid=%v.", test.hook, content, err, test.expected, test.valid)
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+// Docker host running the KDK container.  All fields are optional; the KDK
+// runs on the local engine by default.
+type Host struct {
+	DockerHost string `json:",omitempty"` // remote engine URL (ssh://user@host or tcp://host:2376); defaults to DOCKER_HOST
+	Address    string `json:",omitempty"` // address at which the KDK ssh port is reachable; defaults to the engine host
+	JumpHost   string `json:",omitempty"` // ssh jump host ([user@]host[:port]) through which the KDK is reached
+}
+
+// Host config, never nil
+func (c *KdkEnvConfig) hostConfig() Host {
+	if c.ConfigFile.AppConfig.Host == nil {
+		return Host{}
+	}
+	return *c.ConfigFile.AppConfig.Host
+}
+
+// Returns the URL of the docker engine: the configured host, otherwise DOCKER_HOST
+func (c *KdkEnvConfig) DockerHost() string {
+	if dockerHost := c.hostConfig().DockerHost; dockerHost != "" {
+		return dockerHost
+	}
+	return os.Getenv("DOCKER_HOST")
+}
+
+// check if the docker engine runs on another machine
+func (c *KdkEnvConfig) IsRemoteEngine() bool {
+	return engineHostname(c.DockerHost()) != ""
+}
+
+// Returns the host name of a remote ssh:// or tcp:// engine URL, or an empty
+// string for a local engine
+func engineHostname(dockerHost string) string {
+	u, err := url.Parse(dockerHost)
+	if err != nil || (u.Scheme != "ssh" && u.Scheme != "tcp") {
+		return ""
+	}
+	hostname := u.Hostname()
+	if hostname == "localhost" {
+		return ""
+	}
+	if ip := net.ParseIP(hostname); ip != nil && ip.IsLoopback() {
+		return ""
+	}
+	return hostname
+}
+
+// Returns the ssh jump host through which the KDK is reached, if any
+func (c *KdkEnvConfig) SSHJumpHost() string {
+	if jumpHost := c.hostConfig().JumpHost; jumpHost != "" {
+		return jumpHost
+	}
+	if c.jumpsThroughEngine() {
+		return engineSSHDestination(c.DockerHost())
+	}
+	return ""
+}
+
+// check if the KDK is reached by jumping through the host of an ssh:// engine.
+// This is the case when its ssh port is bound to loopback on the engine host
+// and no other route to it is configured.
+func (c *KdkEnvConfig) jumpsThroughEngine() bool {
+	host := c.hostConfig()
+	return strings.HasPrefix(c.DockerHost(), "ssh://") && c.IsRemoteEngine() &&
+		host.Address == "" && host.JumpHost == "" && isLoopback(c.BindAddress())
+}
+
+// check if the KDK ssh port on a remote engine can be reached from this host
+func (c *KdkEnvConfig) sshReachableOnEngine() bool {
+	return !isLoopback(c.BindAddress()) || c.hostConfig().Address != "" || c.SSHJumpHost() != ""
+}
+
+// Returns the [user@]host[:port] ssh destination of an ssh:// engine URL
+func engineSSHDestination(dockerHost string) string {
+	u, err := url.Parse(dockerHost)
+	if err != nil {
+		return ""
+	}
+	if u.User != nil && u.User.Username() != "" {
+		return u.User.Username() + "@" + u.Host
+	}
+	return u.Host
+}
+
+// Laptop paths cannot be bind mounted into a container on a remote engine.
+// The ssh public key mount is exempt since it is copied in at creation.
+func validateRemoteMounts(cfg KdkEnvConfig) error {
+	if !cfg.IsRemoteEngine() {
+		return nil
+	}
+	for _, m := range cfg.ConfigFile.HostConfig.Mounts {
+		if m.Type == mount.TypeBind && m.Target != publicKeyContainerPath {
+			return fmt.Errorf("cannot bind mount host path [%s] into KDK on remote engine [%s]; "+
+				"replace it with a named volume (Type: volume) in %s", m.Source, cfg.DockerHost(), cfg.ConfigPath())
+		}
+	}
+	for _, bind := range cfg.ConfigFile.HostConfig.Binds {
+		return fmt.Errorf("cannot bind mount [%s] into KDK on remote engine [%s]; "+
+			"replace it with a named volume in %s", bind, cfg.DockerHost(), cfg.ConfigPath())
+	}
+	return nil
+}
+
+// Copies the ssh public key into a container on a remote engine, where it
+// cannot be bind mounted
+func copyPublicKeyToContainer(cfg KdkEnvConfig, containerID string) error {
+	publicKey, err := ioutil.ReadFile(cfg.PublicKeyPath())
+	if err != nil {
+		return err
+	}
+	return copyToContainer(cfg, containerID, containerFile{Path: publicKeyContainerPath, Content: publicKey, Mode: 0644})
+}
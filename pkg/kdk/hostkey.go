@@ -0,0 +1,215 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	kdkssh "github.com/cisco-sso/kdk/pkg/ssh"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Size of the generated per-KDK RSA host key
+	hostKeyBits = 3072
+
+	// Container paths of the sshd RSA host key, which is replaced by the KDK host key
+	hostKeyContainerPath       = "/etc/ssh/ssh_host_rsa_key"
+	hostPublicKeyContainerPath = "/etc/ssh/ssh_host_rsa_key.pub"
+
+	// Container path of the sshd config, whose only HostKey must be the KDK host key
+	sshdConfigContainerPath = "/etc/ssh/sshd_config"
+)
+
+var (
+	// Exits zero when the KDK host key is the only host key sshd offers
+	sshdHostKeyPinnedScript = fmt.Sprintf(`[ "$(grep -E '^[[:space:]]*HostKey[[:space:]]' %s)" = "HostKey %s" ]`,
+		sshdConfigContainerPath, hostKeyContainerPath)
+
+	// Replaces the HostKey options of sshd, which default to every generated key type, with the KDK host key
+	sshdPinHostKeyScript = fmt.Sprintf(`sed -i -e '/^[[:space:]#]*HostKey[[:space:]]/d' -e '1i HostKey %s' %s`,
+		hostKeyContainerPath, sshdConfigContainerPath)
+)
+
+// kdk container sshd host private key path (~/.kdk/<KDK_NAME>/ssh_host_rsa_key)
+func (c *KdkEnvConfig) HostKeyPath() (out string) {
+	return filepath.Join(c.ConfigDir(), "ssh_host_rsa_key")
+}
+
+// kdk container sshd host public key path (~/.kdk/<KDK_NAME>/ssh_host_rsa_key.pub)
+func (c *KdkEnvConfig) HostPublicKeyPath() (out string) {
+	return filepath.Join(c.ConfigDir(), "ssh_host_rsa_key.pub")
+}
+
+// kdk known_hosts path pinning the KDK host key (~/.kdk/<KDK_NAME>/known_hosts)
+func (c *KdkEnvConfig) KnownHostsPath() (out string) {
+	return filepath.Join(c.ConfigDir(), "known_hosts")
+}
+
+// Name under which the KDK host key is pinned, regardless of the address and port it is reached at
+func (c *KdkEnvConfig) HostKeyAlias() string {
+	return "kdk-" + c.ConfigFile.AppConfig.Name
+}
+
+// Creates the KDK sshd host key and the known_hosts file pinning it, unless they exist
+func (c *KdkEnvConfig) CreateKdkHostKey() error {
+	if _, err := os.Stat(c.HostKeyPath()); os.IsNotExist(err) {
+		log.Info("Generating KDK ssh host key...")
+		privateKey, err := kdkssh.GeneratePrivateKey(hostKeyBits)
+		if err != nil {
+			return err
+		}
+		publicKeyBytes, err := kdkssh.GeneratePublicKey(&privateKey.PublicKey)
+		if err != nil {
+			return err
+		}
+		if err := kdkssh.WriteKeyToFile(kdkssh.EncodePrivateKey(privateKey), c.HostKeyPath()); err != nil {
+			return err
+		}
+		if err := kdkssh.WriteKeyToFile(publicKeyBytes, c.HostPublicKeyPath()); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	changed, err := c.pinHostKey()
+	if err != nil || !changed {
+		return err
+	}
+	// ssh configs written by earlier versions only verify host keys once they are pinned
+	c.refreshSSHConfig()
+	return nil
+}
+
+// Writes the known_hosts file pinning the existing KDK host key, reporting whether it changed
+func (c *KdkEnvConfig) pinHostKey() (bool, error) {
+	publicKey, err := c.hostPublicKey()
+	if err != nil {
+		return false, err
+	}
+	knownHosts := []byte(c.HostKeyAlias() + " " + string(ssh.MarshalAuthorizedKey(publicKey)))
+	if existing, err := ioutil.ReadFile(c.KnownHostsPath()); err == nil && bytes.Equal(existing, knownHosts) {
+		return false, nil
+	}
+	if err := ioutil.WriteFile(c.KnownHostsPath(), knownHosts, 0600); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Pins the KDK host key again if its known_hosts file is missing.  Host key
+// verification fails, rather than being skipped, if it cannot be pinned.
+func (c *KdkEnvConfig) ensureKnownHosts() {
+	if _, err := os.Stat(c.KnownHostsPath()); err == nil {
+		return
+	}
+	if _, err := c.pinHostKey(); err != nil {
+		log.WithField("error", err).Warn("Failed to pin KDK ssh host key.  Run `kdk up` to create it.")
+	}
+}
+
+// Returns the pinned KDK host public key
+func (c *KdkEnvConfig) hostPublicKey() (ssh.PublicKey, error) {
+	publicKeyBytes, err := ioutil.ReadFile(c.HostPublicKeyPath())
+	if err != nil {
+		return nil, err
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(publicKeyBytes)
+	return publicKey, err
+}
+
+// Returns the ssh options verifying the KDK host key
+func (c *KdkEnvConfig) hostKeyOptions() string {
+	c.ensureKnownHosts()
+	return fmt.Sprintf("-o HostKeyAlias=%s -o StrictHostKeyChecking=yes -o UserKnownHostsFile=%s",
+		c.HostKeyAlias(), c.KnownHostsPath())
+}
+
+// Returns the host key files copied into the container
+func hostKeyFiles(cfg KdkEnvConfig) ([]containerFile, error) {
+	privateKey, err := ioutil.ReadFile(cfg.HostKeyPath())
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := ioutil.ReadFile(cfg.HostPublicKeyPath())
+	if err != nil {
+		return nil, err
+	}
+	return []containerFile{
+		{Path: hostKeyContainerPath, Content: privateKey, Mode: 0600},
+		{Path: hostPublicKeyContainerPath, Content: publicKey, Mode: 0644},
+	}, nil
+}
+
+// Installs the KDK host key into a running container whose sshd presents
+// another key, which is the case for containers created before host keys were
+// pinned, restricts sshd to that key and reloads sshd.
+func ensureHostKey(cfg KdkEnvConfig) error {
+	files, err := hostKeyFiles(cfg)
+	if err != nil {
+		return err
+	}
+	var installed bytes.Buffer
+	if _, err := containerExec(cfg, "", []string{"cat", hostPublicKeyContainerPath}, &installed, ioutil.Discard); err != nil {
+		return err
+	}
+	keyInstalled := sameKey(installed.Bytes(), files[1].Content)
+	exitCode, err := containerExec(cfg, "", []string{"sh", "-c", sshdHostKeyPinnedScript}, ioutil.Discard, ioutil.Discard)
+	if err != nil {
+		return err
+	}
+	keyPinned := exitCode == 0
+	if keyInstalled && keyPinned {
+		return nil
+	}
+
+	if !keyInstalled {
+		log.Info("Installing KDK ssh host key")
+		if err := copyToContainer(cfg, cfg.ConfigFile.AppConfig.Name, files...); err != nil {
+			return err
+		}
+	}
+	if !keyPinned {
+		log.Info("Restricting KDK sshd to the KDK ssh host key")
+		exitCode, err := containerExec(cfg, "", []string{"sh", "-c", sshdPinHostKeyScript}, ioutil.Discard, ioutil.Discard)
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			return fmt.Errorf("failed to restrict sshd to the KDK host key")
+		}
+	}
+	exitCode, err = containerExec(cfg, "", []string{"sh", "-c", "systemctl reload ssh || pkill -HUP -x sshd"}, ioutil.Discard, ioutil.Discard)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("failed to reload sshd with the KDK host key")
+	}
+	return nil
+}
+
+// check if two authorized_keys formatted keys are the same, ignoring comments
+func sameKey(a, b []byte) bool {
+	aFields, bFields := strings.Fields(string(a)), strings.Fields(string(b))
+	return len(aFields) >= 2 && len(bFields) >= 2 && aFields[0] == bFields[0] && aFields[1] == bFields[1]
+}
@@ -0,0 +1,99 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+func TestCreateKdkHostKeyPinsKeyUnderAlias(t *testing.T) {
+
+	home, err := ioutil.TempDir("", "kdk-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+	homedir.DisableCache = true
+	defer func() { homedir.DisableCache = false }()
+
+	cfg := KdkEnvConfig{}
+	cfg.ConfigFile.AppConfig.Name = "kdk1"
+	if err := os.MkdirAll(cfg.ConfigDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.CreateKdkHostKey(); err != nil {
+		t.Fatal(err)
+	}
+	publicKey, _ := ioutil.ReadFile(cfg.HostPublicKeyPath())
+
+	// A second run keeps the existing key
+	if err := cfg.CreateKdkHostKey(); err != nil {
+		t.Fatal(err)
+	}
+	knownHosts, err := ioutil.ReadFile(cfg.KnownHostsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(knownHosts), "kdk-kdk1 ssh-rsa ") || !sameKey(knownHosts[len("kdk-kdk1 "):], publicKey) {
+		t.Logf("known_hosts is %q, expected the host key under alias kdk-kdk1.", knownHosts)
+		t.FailNow()
+	}
+	if !strings.Contains(cfg.SSHCommandString(), "-o HostKeyAlias=kdk-kdk1 -o StrictHostKeyChecking=yes") {
+		t.Logf("ssh command %q does not verify the host key.", cfg.SSHCommandString())
+		t.FailNow()
+	}
+}
+
+func TestHostKeyOptionsRepinMissingKnownHosts(t *testing.T) {
+	defer useTempHome(t)()
+
+	cfg := KdkEnvConfig{}
+	cfg.ConfigFile.AppConfig.Name = "kdk1"
+	if err := os.MkdirAll(cfg.ConfigDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a host key, verification fails rather than being skipped
+	if options := cfg.hostKeyOptions(); !strings.Contains(options, "StrictHostKeyChecking=yes") {
+		t.Logf("ssh options %q do not verify the host key.", options)
+		t.FailNow()
+	}
+	var sshConfig strings.Builder
+	writeSSHConfigEntry(&sshConfig, &cfg)
+	if strings.Contains(sshConfig.String(), "StrictHostKeyChecking no") {
+		t.Logf("ssh config skips host key verification:\n%s", sshConfig.String())
+		t.FailNow()
+	}
+
+	if err := cfg.CreateKdkHostKey(); err != nil {
+		t.Fatal(err)
+	}
+	knownHosts, _ := ioutil.ReadFile(cfg.KnownHostsPath())
+	if err := os.Remove(cfg.KnownHostsPath()); err != nil {
+		t.Fatal(err)
+	}
+	cfg.hostKeyOptions()
+	if repinned, err := ioutil.ReadFile(cfg.KnownHostsPath()); err != nil || string(repinned) != string(knownHosts) {
+		t.Logf("known_hosts is %q after it went missing, expected %q.", repinned, knownHosts)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/cisco-sso/kdk/pkg/prompt"
+	log "github.com/sirupsen/logrus"
+)
+
+// File name of the kdk binary
+func binaryName() string {
+	if runtime.GOOS == "windows" {
+		return "kdk.exe"
+	}
+	return "kdk"
+}
+
+// Directory of user-local kdk installs: $XDG_BIN_HOME, otherwise ~/.kdk/bin
+func (c *KdkEnvConfig) UserBinDir() (out string) {
+	if dir := os.Getenv("XDG_BIN_HOME"); dir != "" {
+		return dir
+	}
+	return c.BinDir()
+}
+
+// Path of the running kdk binary, with symlinks resolved
+func runningBinaryPath() (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(path)
+}
+
+// check if the current user can create files in a directory
+func dirWritable(dir string) bool {
+	fd, err := ioutil.TempFile(dir, ".kdk-write-test")
+	if err != nil {
+		return false
+	}
+	fd.Close()
+	os.Remove(fd.Name())
+	return true
+}
+
+// Returns the path at which the kdk binary is updated: the running binary when
+// its directory is writable, so that system-wide installs keep working under
+// sudo.  Otherwise offers to install into the user bin directory instead.
+func (c *KdkEnvConfig) binaryInstallPath() (string, error) {
+	current, err := runningBinaryPath()
+	if err != nil {
+		return "", err
+	}
+	if dirWritable(filepath.Dir(current)) {
+		return current, nil
+	}
+
+	userBinFile := filepath.Join(c.UserBinDir(), binaryName())
+	log.Warnf("KDK binary [%s] is not writable by the current user", current)
+	prmpt := prompt.Prompt{
+		Text:     fmt.Sprintf("Install kdk into %s instead? [y/n] ", c.UserBinDir()),
+		Loop:     true,
+		Validate: prompt.ValidateYorN,
+	}
+	if result, err := prmpt.Run(); err != nil || result == "n" {
+		return "", errors.New("updating the KDK binary at " + current + " requires `sudo` or the `root` user")
+	}
+	return userBinFile, nil
+}
+
+// Installs the running kdk binary into the user bin directory
+func Install(cfg *KdkEnvConfig) (result InstallResult, err error) {
+	current, err := runningBinaryPath()
+	if err != nil {
+		return result, err
+	}
+	result.Path = filepath.Join(cfg.UserBinDir(), binaryName())
+	if current != result.Path {
+		if err := os.MkdirAll(cfg.UserBinDir(), 0755); err != nil {
+			return result, err
+		}
+		if err := installBinary(cfg, current, result.Path); err != nil {
+			return result, err
+		}
+		log.WithField("file", result.Path).Info("Installed KDK binary")
+	}
+	result.OnPath = warnIfNotOnPath(result.Path)
+	return result, nil
+}
+
+// Warns with a hint when the kdk command does not resolve to the binary at
+// path, and returns whether it does
+func warnIfNotOnPath(path string) bool {
+	dir := filepath.Dir(path)
+	for _, pathDir := range filepath.SplitList(os.Getenv("PATH")) {
+		candidate := filepath.Join(pathDir, binaryName())
+		if _, err := os.Stat(candidate); err != nil {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(candidate); err == nil && resolved == path {
+			return true
+		}
+		// An earlier install shadows this one
+		log.Warnf("`kdk` runs [%s], which shadows [%s].  Remove it, or put %s first in your PATH", candidate, path, dir)
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		log.Warnf("%s is not in your PATH.  Add it with: setx PATH \"%s;%%PATH%%\"", dir, dir)
+	} else {
+		log.Warnf("%s is not in your PATH.  Add it to your shell profile with: export PATH=\"%s:$PATH\"", dir, dir)
+	}
+	return false
+}
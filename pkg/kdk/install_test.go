@@ -0,0 +1,85 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInstallIntoUserBinDir(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "kdk-bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, _ = filepath.EvalSymlinks(dir)
+	binDir := filepath.Join(dir, "bin")
+	defer os.Setenv("XDG_BIN_HOME", os.Getenv("XDG_BIN_HOME"))
+	os.Setenv("XDG_BIN_HOME", binDir)
+
+	cfg := KdkEnvConfig{}
+	result, err := Install(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Path != filepath.Join(binDir, binaryName()) || result.OnPath {
+		t.Logf("Install result is %+v, expected %s not on PATH.", result, filepath.Join(binDir, binaryName()))
+		t.FailNow()
+	}
+	if info, err := os.Stat(result.Path); err != nil || info.Mode()&0111 == 0 {
+		t.Logf("Installed binary is missing or not executable: %v", err)
+		t.FailNow()
+	}
+
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	if !warnIfNotOnPath(result.Path) {
+		t.Logf("%s is first in PATH but not found.", binDir)
+		t.FailNow()
+	}
+	if !dirWritable(binDir) || dirWritable(filepath.Join(dir, "missing")) {
+		t.Log("dirWritable misreports writable directories.")
+		t.FailNow()
+	}
+}
+
+func TestInstallBinaryCreatesMissingDir(t *testing.T) {
+	defer useTempHome(t)()
+
+	dir, err := ioutil.TempDir("", "kdk-bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	newBinFile := filepath.Join(dir, "kdk-download")
+	if err := ioutil.WriteFile(newBinFile, []byte("kdk"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	kdkBinFile := filepath.Join(dir, "missing", "bin", binaryName())
+	cfg := KdkEnvConfig{}
+	if err := installBinary(&cfg, newBinFile, kdkBinFile); err != nil {
+		t.Logf("Failed to install into a missing directory: %v", err)
+		t.FailNow()
+	}
+	if data, err := ioutil.ReadFile(kdkBinFile); err != nil || string(data) != "kdk" {
+		t.Logf("Installed binary is missing or wrong: %v", err)
+		t.FailNow()
+	}
+}
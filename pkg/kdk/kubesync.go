@@ -38,7 +38,7 @@ func Kubesync(cfg KdkEnvConfig) {
 	}
 
 	// Tune Docker for Desktop's Kubernetes API hostname in KUBECONFIG
-	remoteCommand = "sed -i -e 's@localhost@host.docker.internal@g' -e 's@docker-for-desktop.*@docker-for-desktop.example.org@g' " + kubeconfigKDKPath
+	remoteCommand = "sed -i -e 's@localhost@" + cfg.HostGateway() + "@g' -e 's@docker-for-desktop.*@docker-for-desktop.example.org@g' " + kubeconfigKDKPath
 	if err := cfg.Exec(remoteCommand); err != nil {
 		log.WithField("error", err).Fatal("Failed to transform KUBECONFIG in KDK container.")
 	}
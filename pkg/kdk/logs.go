@@ -0,0 +1,207 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	log "github.com/sirupsen/logrus"
+)
+
+// Log sources available to `kdk logs`
+const (
+	LogSourceContainer = "container" // container stdout/stderr (systemd console)
+	LogSourceDockerd   = "dockerd"   // nested dockerd journal
+	LogSourceProvision = "provision" // full provisioning log on the host (~/.kdk/<KDK_NAME>/provision.log)
+	LogSourceSetup     = "setup"     // kdk-setup.service journal
+)
+
+var LogSources = []string{LogSourceContainer, LogSourceDockerd, LogSourceProvision, LogSourceSetup}
+
+// Options for Logs, mirroring `docker logs`
+type LogsOptions struct {
+	Source string
+	Follow bool
+	Since  string // timestamp or relative duration (e.g. 10m)
+	Tail   string // number of lines or "all"
+}
+
+// Streams logs of the given source to stdout and stderr
+func Logs(cfg KdkEnvConfig, opts LogsOptions) error {
+	switch opts.Source {
+	case LogSourceContainer:
+		return containerLogs(cfg, opts)
+	case LogSourceDockerd:
+		return journalLogs(cfg, "docker.service", opts)
+	case LogSourceSetup:
+		return journalLogs(cfg, "kdk-setup.service", opts)
+	case LogSourceProvision:
+		if opts.Since != "" {
+			log.Warn("--since is not supported for provision logs and is ignored")
+		}
+		return fileLogs(cfg.ProvisionLogPath(), opts, os.Stdout)
+	}
+	return fmt.Errorf("unknown log source [%s]", opts.Source)
+}
+
+// Interval at which a followed log file is checked for new output
+var logFollowInterval = 500 * time.Millisecond
+
+// Writes the tail of a host log file to out, then follows it if requested
+func fileLogs(path string, opts LogsOptions, out io.Writer) error {
+	lines := -1
+	if tail := tailLines(opts.Tail, ""); tail != "" {
+		n, err := strconv.Atoi(tail)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid --tail [%s]", opts.Tail)
+		}
+		lines = n
+	}
+	// A log that does not exist yet may still be followed
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !(os.IsNotExist(err) && opts.Follow) {
+		return err
+	}
+	if _, err := out.Write(lastLines(data, lines)); err != nil {
+		return err
+	}
+	if !opts.Follow {
+		return nil
+	}
+
+	// Provisioning recreates the log, so a file shorter than what was read is read again from the start
+	offset := int64(len(data))
+	for {
+		time.Sleep(logFollowInterval)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.Size() < offset {
+			offset = 0
+		}
+		if info.Size() == offset {
+			continue
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		n, err := io.Copy(out, io.NewSectionReader(file, offset, info.Size()-offset))
+		file.Close()
+		if err != nil {
+			return err
+		}
+		offset += n
+	}
+}
+
+// Returns the last n lines of data, or all of it if n is negative
+func lastLines(data []byte, n int) []byte {
+	if n < 0 {
+		return data
+	}
+	if n == 0 {
+		return nil
+	}
+	end := len(data)
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+	for i := end - 1; i >= 0; i-- {
+		if data[i] == '\n' {
+			if n--; n <= 0 {
+				return data[i+1:]
+			}
+		}
+	}
+	return data
+}
+
+func containerLogs(cfg KdkEnvConfig, opts LogsOptions) error {
+	containerJSON, err := cfg.DockerClient.ContainerInspect(cfg.Ctx, cfg.ConfigFile.AppConfig.Name)
+	if err != nil {
+		return err
+	}
+	reader, err := cfg.DockerClient.ContainerLogs(cfg.Ctx, containerJSON.ID, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     opts.Follow,
+		Since:      opts.Since,
+		Tail:       opts.Tail,
+		Timestamps: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	// Output of a container with a TTY is a single raw stream
+	if containerJSON.Config != nil && containerJSON.Config.Tty {
+		_, err = io.Copy(os.Stdout, reader)
+		return err
+	}
+	_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, reader)
+	return err
+}
+
+func journalLogs(cfg KdkEnvConfig, unit string, opts LogsOptions) error {
+	command := []string{"journalctl", "--no-pager", "--output=short-iso", "--unit=" + unit}
+	if opts.Since != "" {
+		command = append(command, "--since="+journalSince(opts.Since))
+	}
+	if lines := tailLines(opts.Tail, ""); lines != "" {
+		command = append(command, "--lines="+lines)
+	}
+	if opts.Follow {
+		command = append(command, "--follow")
+	}
+	return execLogs(cfg, command)
+}
+
+func execLogs(cfg KdkEnvConfig, command []string) error {
+	exitCode, err := containerExec(cfg, "", command, os.Stdout, os.Stderr)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("%s exited with status %d", command[0], exitCode)
+	}
+	return nil
+}
+
+// Converts a `docker logs` style --since into a journalctl --since argument.
+// Relative durations become a negative offset from now.
+func journalSince(since string) string {
+	if duration, err := time.ParseDuration(since); err == nil {
+		return fmt.Sprintf("-%ds", int(duration.Seconds()))
+	}
+	return since
+}
+
+// Returns the --tail line count, or all if it is empty or "all"
+func tailLines(tail, all string) string {
+	if tail == "" || tail == "all" {
+		return all
+	}
+	return tail
+}
@@ -0,0 +1,75 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLastLines(t *testing.T) {
+
+	tests := []struct {
+		data     string
+		n        int
+		expected string
+	}{
+		{"a\nb\nc\n", -1, "a\nb\nc\n"},
+		{"a\nb\nc\n", 0, ""},
+		{"a\nb\nc\n", 2, "b\nc\n"},
+		{"a\nb\nc", 1, "c"},
+		{"a\nb\nc\n", 5, "a\nb\nc\n"},
+		{"", 3, ""},
+	}
+	for _, test := range tests {
+		if actual := string(lastLines([]byte(test.data), test.n)); actual != test.expected {
+			t.Logf("lastLines(%q, %d) is %q, expected %q.", test.data, test.n, actual, test.expected)
+			t.FailNow()
+		}
+	}
+}
+
+func TestFileLogsReadsHostProvisionLog(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "kdk-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "provision.log")
+	if err := ioutil.WriteFile(path, []byte("cloning dotfiles\nrunning hooks\ndone\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := fileLogs(path, LogsOptions{Tail: "2"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "running hooks\ndone\n" {
+		t.Logf("Provision logs are %q, expected the last two lines.", out.String())
+		t.FailNow()
+	}
+	if err := fileLogs(path, LogsOptions{Tail: "two"}, &out); err == nil {
+		t.Log("Invalid --tail is accepted.")
+		t.FailNow()
+	}
+	if err := fileLogs(filepath.Join(dir, "missing.log"), LogsOptions{}, &out); err == nil {
+		t.Log("Missing provision log is not reported.")
+		t.FailNow()
+	}
+}
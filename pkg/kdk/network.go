@@ -0,0 +1,139 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	log "github.com/sirupsen/logrus"
+)
+
+// Docker network driver option that sets the MTU of a bridge network
+const networkMTUOption = "com.docker.network.driver.mtu"
+
+// Container network configuration.  All fields are optional; the KDK joins
+// the default bridge network and uses the engine's DNS when unset.
+type Network struct {
+	Name           string   `json:",omitempty"` // user-defined bridge network, created on demand
+	DNS            []string `json:",omitempty"` // DNS servers (e.g. VPN resolvers)
+	DNSSearch      []string `json:",omitempty"` // DNS search domains
+	ExtraHosts     []string `json:",omitempty"` // additional /etc/hosts entries (host:ip)
+	AddHostGateway bool     `json:",omitempty"` // map the host gateway name to the docker host (host-gateway)
+	MTU            int      `json:",omitempty"` // MTU of the user-defined network
+}
+
+// Network config, never nil
+func (c *KdkEnvConfig) networkConfig() Network {
+	if c.ConfigFile.AppConfig.Network == nil {
+		return Network{}
+	}
+	return *c.ConfigFile.AppConfig.Network
+}
+
+// Applies the network config to a host config at container creation.  Entries
+// are appended to any set directly in HostConfig.
+func applyNetworkConfig(cfg KdkEnvConfig, hostConfig *container.HostConfig) {
+	network := cfg.networkConfig()
+	if network.Name != "" {
+		hostConfig.NetworkMode = container.NetworkMode(network.Name)
+	}
+	hostConfig.DNS = append(append([]string{}, hostConfig.DNS...), network.DNS...)
+	hostConfig.DNSSearch = append(append([]string{}, hostConfig.DNSSearch...), network.DNSSearch...)
+	hostConfig.ExtraHosts = append(append([]string{}, hostConfig.ExtraHosts...), network.ExtraHosts...)
+	if network.AddHostGateway {
+		hostConfig.ExtraHosts = append(hostConfig.ExtraHosts, cfg.HostGateway()+":host-gateway")
+	}
+}
+
+// Creates the configured user-defined network unless it exists
+func ensureNetwork(cfg KdkEnvConfig) error {
+	network := cfg.networkConfig()
+	if network.Name == "" {
+		if network.MTU != 0 {
+			return errors.New("Network.MTU requires Network.Name; the MTU of the default bridge network is set in the engine config")
+		}
+		return nil
+	}
+
+	existing, err := cfg.DockerClient.NetworkInspect(cfg.Ctx, network.Name, types.NetworkInspectOptions{})
+	if err == nil {
+		if mtu := existing.Options[networkMTUOption]; network.MTU != 0 && mtu != strconv.Itoa(network.MTU) {
+			log.Warnf("KDK network [%s] exists with MTU [%s] rather than %d.  Remove it with `docker network rm %s` to apply the configured MTU",
+				network.Name, mtu, network.MTU, network.Name)
+		}
+		return nil
+	}
+	if !client.IsErrNotFound(err) {
+		return err
+	}
+
+	options := map[string]string{}
+	if network.MTU != 0 {
+		options[networkMTUOption] = strconv.Itoa(network.MTU)
+	}
+	log.Infof("Creating KDK network [%s]", network.Name)
+	_, err = cfg.DockerClient.NetworkCreate(cfg.Ctx, network.Name, types.NetworkCreate{
+		CheckDuplicate: true,
+		Driver:         "bridge",
+		Options:        options,
+		Labels:         map[string]string{"kdk": Version},
+	})
+	return err
+}
+
+// Shows the network config and the effective network settings inside the running KDK container
+func NetworkInspect(cfg KdkEnvConfig, out io.Writer) error {
+	containerJSON, err := cfg.DockerClient.ContainerInspect(cfg.Ctx, cfg.ConfigFile.AppConfig.Name)
+	if err != nil {
+		return err
+	}
+	if containerJSON.State == nil || !containerJSON.State.Running {
+		return errors.New("KDK container is not running")
+	}
+
+	network := cfg.networkConfig()
+	fmt.Fprintln(out, "# Container networks")
+	if containerJSON.NetworkSettings != nil {
+		for name, endpoint := range containerJSON.NetworkSettings.Networks {
+			fmt.Fprintf(out, "%s: address %s/%d, gateway %s\n", name, endpoint.IPAddress, endpoint.IPPrefixLen, endpoint.Gateway)
+		}
+	}
+	if network.MTU != 0 {
+		fmt.Fprintf(out, "configured MTU: %d\n", network.MTU)
+	}
+
+	script := strings.Join([]string{
+		`echo; echo "# /etc/resolv.conf"; cat /etc/resolv.conf`,
+		`echo; echo "# /etc/hosts"; cat /etc/hosts`,
+		`echo; echo "# host gateway"; getent hosts ` + cfg.HostGateway() + ` || echo "` + cfg.HostGateway() + ` does not resolve"`,
+		`echo; echo "# interfaces"; ip addr show 2>/dev/null || cat /proc/net/dev`,
+	}, "; ")
+	exitCode, err := containerExec(cfg, "", []string{"sh", "-c", script}, out, os.Stderr)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("network inspection exited with code %d", exitCode)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestApplyNetworkConfig(t *testing.T) {
+
+	cfg := KdkEnvConfig{engine: EngineDocker}
+	cfg.ConfigFile.AppConfig.Network = &Network{
+		Name:           "kdk-vpn",
+		DNS:            []string{"10.0.0.53"},
+		DNSSearch:      []string{"corp.example.com"},
+		ExtraHosts:     []string{"git.corp.example.com:10.0.0.10"},
+		AddHostGateway: true,
+	}
+	persisted := []string{"8.8.8.8"}
+	hostConfig := container.HostConfig{DNS: persisted}
+
+	applyNetworkConfig(cfg, &hostConfig)
+
+	if hostConfig.NetworkMode != "kdk-vpn" {
+		t.Logf("NetworkMode is %s, expected kdk-vpn.", hostConfig.NetworkMode)
+		t.FailNow()
+	}
+	if !reflect.DeepEqual(hostConfig.DNS, []string{"8.8.8.8", "10.0.0.53"}) {
+		t.Logf("DNS is %v, expected the HostConfig and Network servers.", hostConfig.DNS)
+		t.FailNow()
+	}
+	expectedHosts := []string{"git.corp.example.com:10.0.0.10", "host.docker.internal:host-gateway"}
+	if !reflect.DeepEqual(hostConfig.ExtraHosts, expectedHosts) {
+		t.Logf("ExtraHosts are %v, expected %v.", hostConfig.ExtraHosts, expectedHosts)
+		t.FailNow()
+	}
+	if len(persisted) != 1 {
+		t.Log("applyNetworkConfig modified the persisted config.")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cisco-sso/kdk/pkg/prompt"
+	"github.com/ghodss/yaml"
+)
+
+// Output formats of command results
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+	OutputYAML = "yaml"
+)
+
+var OutputFormats = []string{OutputText, OutputJSON, OutputYAML}
+
+// Writer for human-readable progress output such as image pulls.  It is
+// stderr when stdout carries machine-readable command results.
+var HumanOutput io.Writer = os.Stdout
+
+// Selects the output format.  Machine-readable formats move all human output
+// (logs, prompts and progress) to stderr, leaving only the result on stdout.
+func SetOutputFormat(format string) error {
+	switch format {
+	case OutputText:
+		return nil
+	case OutputJSON, OutputYAML:
+		HumanOutput = os.Stderr
+		prompt.Output = os.Stderr
+		return nil
+	}
+	return fmt.Errorf("unsupported output format [%s]; must be one of %s", format, strings.Join(OutputFormats, "|"))
+}
+
+// Results with a human-readable form in the text output format
+type textResult interface {
+	WriteText(out io.Writer)
+}
+
+// Writes a command result in the given output format.  Results without a
+// text form write nothing in the text format, where their logs suffice.
+func WriteResult(out io.Writer, format string, result interface{}) error {
+	switch format {
+	case OutputText:
+		if text, ok := result.(textResult); ok {
+			text.WriteText(out)
+		}
+		return nil
+	case OutputJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case OutputYAML:
+		y, err := yaml.Marshal(result)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(y)
+		return err
+	}
+	return fmt.Errorf("unsupported output format [%s]", format)
+}
+
+// Command results are part of the kdk interface for scripts: fields may be
+// added, but are never renamed or removed.
+
+// Result of `kdk version`
+type VersionResult struct {
+	Version string `json:"version"`
+	OS      string `json:"os"`
+	Arch    string `json:"arch"`
+}
+
+func NewVersionResult() VersionResult {
+	return VersionResult{Version: Version, OS: runtime.GOOS, Arch: runtime.GOARCH}
+}
+
+// Result of `kdk status`, and of each instance in `kdk list`
+type InstanceStatus struct {
+	Name        string         `json:"name"`
+	State       string         `json:"state"` // docker container state, or "absent" if no container exists
+	ContainerID string         `json:"containerId,omitempty"`
+	Image       string         `json:"image"`
+	SSHAddress  string         `json:"sshAddress"`
+	SSHPort     string         `json:"sshPort"`
+	SocksPort   string         `json:"socksPort,omitempty"`
+	BindAddress string         `json:"bindAddress"`
+	Secrets     *SecretsStatus `json:"secrets,omitempty"`
+}
+
+// Secrets filesystem mounted into a KDK
+type SecretsStatus struct {
+	Provider   string   `json:"provider"`
+	Source     string   `json:"source"`
+	Target     string   `json:"target"`
+	Active     bool     `json:"active"`     // available on the host, e.g. the keybase mirror is running
+	Containers []string `json:"containers"` // running KDK containers that mount it
+}
+
+func (s InstanceStatus) WriteText(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", s.Name)
+	fmt.Fprintf(w, "State:\t%s\n", s.State)
+	fmt.Fprintf(w, "Image:\t%s\n", s.Image)
+	fmt.Fprintf(w, "SSH:\t%s\n", s.SSHAddress)
+	if s.SocksPort != "" {
+		fmt.Fprintf(w, "SOCKS port:\t%s\n", s.SocksPort)
+	}
+	if s.Secrets != nil {
+		state := "inactive"
+		if s.Secrets.Active {
+			state = "active"
+		}
+		fmt.Fprintf(w, "Secrets:\t%s at %s (%s, used by %d KDKs)\n", s.Secrets.Provider, s.Secrets.Target, state, len(s.Secrets.Containers))
+	}
+	w.Flush()
+}
+
+// Result of `kdk list`
+type ListResult struct {
+	Instances []InstanceStatus `json:"instances"`
+}
+
+func (r ListResult) WriteText(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATE\tSSH\tSOCKS\tIMAGE")
+	for _, instance := range r.Instances {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", instance.Name, instance.State, instance.SSHAddress, instance.SocksPort, instance.Image)
+	}
+	w.Flush()
+}
+
+// A snapshot image of a KDK container
+type SnapshotResult struct {
+	Image   string    `json:"image"`
+	ID      string    `json:"id,omitempty"`
+	Created time.Time `json:"created"`
+	Size    int64     `json:"size,omitempty"`
+}
+
+// Result of `kdk snapshot list`
+type SnapshotListResult struct {
+	Snapshots []SnapshotResult `json:"snapshots"`
+}
+
+func (r SnapshotListResult) WriteText(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "IMAGE\tCREATED\tSIZE")
+	for _, snapshot := range r.Snapshots {
+		fmt.Fprintf(w, "%s\t%s\t%dMB\n", snapshot.Image, snapshot.Created.Format(time.RFC3339), snapshot.Size/1000/1000)
+	}
+	w.Flush()
+}
+
+// Result of `kdk prune`
+type PruneResult struct {
+	Deleted []string `json:"deleted"` // IDs of deleted images
+}
+
+// Result of `kdk update`
+type UpdateResult struct {
+	LatestVersion string `json:"latestVersion"`
+	TargetVersion string `json:"targetVersion"` // version updated or rolled back to
+	BinaryUpdated bool   `json:"binaryUpdated"`
+	ImageUpdated  bool   `json:"imageUpdated"`
+	ConfigUpdated bool   `json:"configUpdated"`
+	RolledBack    bool   `json:"rolledBack"`
+}
+
+// Result of `kdk install`
+type InstallResult struct {
+	Path   string `json:"path"`   // installed kdk binary
+	OnPath bool   `json:"onPath"` // the kdk command runs the installed binary
+}
+
+// Result of `kdk upgrade`
+type UpgradeResult struct {
+	PreviousVersion    string `json:"previousVersion"`
+	Version            string `json:"version"`
+	BinaryUpdated      bool   `json:"binaryUpdated"`
+	ImageUpdated       bool   `json:"imageUpdated"`
+	ConfigUpdated      bool   `json:"configUpdated"`
+	ContainerRecreated bool   `json:"containerRecreated"`
+	Snapshot           string `json:"snapshot,omitempty"` // snapshot image taken before the container was replaced
+	RolledBack         bool   `json:"rolledBack"`         // the upgrade failed and was undone
+}
@@ -0,0 +1,47 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteResult(t *testing.T) {
+
+	result := ListResult{Instances: []InstanceStatus{{Name: "kdk", State: "running", SSHPort: "40000"}}}
+
+	var out bytes.Buffer
+	if err := WriteResult(&out, OutputYAML, result); err != nil {
+		t.Fatal(err)
+	}
+	expected := "instances:\n- bindAddress: \"\"\n  image: \"\"\n  name: kdk\n  sshAddress: \"\"\n  sshPort: \"40000\"\n  state: running\n"
+	if out.String() != expected {
+		t.Logf("YAML result is %q, expected %q.", out.String(), expected)
+		t.FailNow()
+	}
+
+	// Results without a text form leave text output to the logs
+	out.Reset()
+	if err := WriteResult(&out, OutputText, PruneResult{}); err != nil || out.Len() != 0 {
+		t.Logf("Text result is %q (err %v), expected nothing.", out.String(), err)
+		t.FailNow()
+	}
+
+	if err := WriteResult(&out, "xml", result); err == nil {
+		t.Log("Unsupported output format was accepted.")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/cisco-sso/kdk/pkg/prompt"
+	"github.com/cisco-sso/kdk/pkg/utils"
+	"github.com/ghodss/yaml"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// First port tried for SOCKS proxies
+	defaultSocksPort = 8000
+
+	// Host address on which the KDK ssh port is published by default
+	defaultBindAddress = "127.0.0.1"
+)
+
+// Host ports assigned to a KDK instance
+type InstancePorts struct {
+	SSH   string `json:",omitempty"`
+	Socks string `json:",omitempty"`
+}
+
+// Host ports assigned to every KDK instance, keyed by KDK name
+type portRegistry map[string]InstancePorts
+
+// kdk port registry path (~/.kdk/ports.yaml)
+func (c *KdkEnvConfig) PortRegistryPath() (out string) {
+	return filepath.Join(c.ConfigRootDir(), "ports.yaml")
+}
+
+// Loads the port registry, reconciled with the config of every KDK instance.
+// Instance configs take precedence, and instances without a config are dropped.
+func loadPortRegistry(rootDir, registryPath string) (portRegistry, error) {
+	registry := portRegistry{}
+	data, err := ioutil.ReadFile(registryPath)
+	if err == nil {
+		if err := yaml.Unmarshal(data, &registry); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for name := range registry {
+		if _, err := os.Stat(filepath.Join(rootDir, name, "config.yaml")); os.IsNotExist(err) {
+			delete(registry, name)
+		}
+	}
+
+	instanceConfigs, err := loadInstanceConfigs(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	for name, instanceConfig := range instanceConfigs {
+		registry[name] = InstancePorts{
+			SSH:   instanceConfig.AppConfig.Port,
+			Socks: instanceConfig.AppConfig.SocksPort,
+		}
+	}
+	return registry, nil
+}
+
+// Loads the config of every KDK instance, keyed by KDK name.  Unreadable configs are skipped.
+func loadInstanceConfigs(rootDir string) (map[string]configFile, error) {
+	configPaths, err := filepath.Glob(filepath.Join(rootDir, "*", "config.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	instanceConfigs := map[string]configFile{}
+	for _, configPath := range configPaths {
+		data, err := ioutil.ReadFile(configPath)
+		if err != nil {
+			continue
+		}
+		var instanceConfig configFile
+		if err := yaml.Unmarshal(data, &instanceConfig); err != nil {
+			log.WithFields(log.Fields{"error": err, "file": configPath}).Debug("Skipping unreadable KDK config")
+			continue
+		}
+		instanceConfigs[filepath.Base(filepath.Dir(configPath))] = instanceConfig
+	}
+	return instanceConfigs, nil
+}
+
+func (r portRegistry) save(registryPath string) error {
+	y, err := yaml.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(registryPath, y, 0600)
+}
+
+// Returns the name of another instance that is assigned the port, if any
+func (r portRegistry) owner(port, self string) string {
+	for name, ports := range r {
+		if name != self && (ports.SSH == port || ports.Socks == port) {
+			return name
+		}
+	}
+	return ""
+}
+
+// Returns the first port from start upward that no other instance is assigned and that is available
+func (r portRegistry) allocate(self string, start int, available func(int) bool) (string, error) {
+	for port := start; port <= 65535; port++ {
+		if r.owner(strconv.Itoa(port), self) == "" && available(port) {
+			return strconv.Itoa(port), nil
+		}
+	}
+	return "", fmt.Errorf("no free port at or above %d", start)
+}
+
+// Returns why a port cannot be used by the instance, or an empty string if it can
+func (r portRegistry) conflict(port, self string, checkListener bool) string {
+	if owner := r.owner(port, self); owner != "" {
+		return "is assigned to KDK [" + owner + "]"
+	}
+	if number, err := strconv.Atoi(port); err == nil && checkListener && !utils.PortAvailable(number) {
+		return "is in use by another program"
+	}
+	return ""
+}
+
+// Checks the ssh and SOCKS ports of the KDK against every other instance and,
+// when checkListeners is set, against host listeners.  Conflicting ports are
+// reassigned after confirmation and the ports are recorded in the registry.
+// Returns whether the config was changed.
+func reservePorts(c *KdkEnvConfig, checkListeners bool) (changed bool, err error) {
+	registry, err := loadPortRegistry(c.ConfigRootDir(), c.PortRegistryPath())
+	if err != nil {
+		return false, err
+	}
+	name := c.ConfigFile.AppConfig.Name
+	available := func(port int) bool { return !checkListeners || utils.PortAvailable(port) }
+
+	// The ssh port is published on the docker host, which can't be probed when it is remote
+	sshAvailable := available
+	if c.IsRemoteEngine() {
+		sshAvailable = func(int) bool { return true }
+	}
+
+	if c.ConfigFile.AppConfig.Port == "" {
+		port, err := registry.allocate(name, utils.GetPort(), sshAvailable)
+		if err != nil {
+			return false, err
+		}
+		c.setSSHPort(port)
+		changed = true
+	} else if reason := registry.conflict(c.ConfigFile.AppConfig.Port, name, checkListeners && !c.IsRemoteEngine()); reason != "" {
+		port, err := offerPortReassignment(registry, name, "ssh", c.ConfigFile.AppConfig.Port, reason, utils.GetPort(), sshAvailable)
+		if err != nil {
+			return false, err
+		}
+		c.setSSHPort(port)
+		changed = true
+	}
+
+	if socksPort := c.ConfigFile.AppConfig.SocksPort; socksPort != "" {
+		if reason := registry.conflict(socksPort, name, checkListeners); reason != "" {
+			port, err := offerPortReassignment(registry, name, "SOCKS", socksPort, reason, defaultSocksPort, available)
+			if err != nil {
+				return false, err
+			}
+			c.ConfigFile.AppConfig.SocksPort = port
+			changed = true
+		}
+	}
+
+	registry[name] = InstancePorts{SSH: c.ConfigFile.AppConfig.Port, Socks: c.ConfigFile.AppConfig.SocksPort}
+	if err := registry.save(c.PortRegistryPath()); err != nil {
+		return changed, err
+	}
+	return changed, nil
+}
+
+func offerPortReassignment(registry portRegistry, name, kind, port, reason string, start int, available func(int) bool) (string, error) {
+	newPort, err := registry.allocate(name, start, available)
+	if err != nil {
+		return "", err
+	}
+	log.Warnf("KDK %s port %s %s", kind, port, reason)
+	prmpt := prompt.Prompt{
+		Text:     fmt.Sprintf("Reassign KDK %s port to %s? [y/n] ", kind, newPort),
+		Loop:     true,
+		Validate: prompt.ValidateYorN,
+	}
+	if result, err := prmpt.Run(); err != nil || result == "n" {
+		return "", errors.New("KDK " + kind + " port " + port + " " + reason)
+	}
+	log.Infof("Reassigned KDK %s port %s to %s", kind, port, newPort)
+	return newPort, nil
+}
+
+// Sets the host port of the KDK sshd, including its published port binding
+func (c *KdkEnvConfig) setSSHPort(port string) {
+	c.ConfigFile.AppConfig.Port = port
+	if c.ConfigFile.HostConfig == nil {
+		return
+	}
+	for i := range c.ConfigFile.HostConfig.PortBindings["2022/tcp"] {
+		c.ConfigFile.HostConfig.PortBindings["2022/tcp"][i].HostPort = port
+	}
+}
+
+// Returns the first SOCKS port from the default upward that is free on the host and not assigned to another instance
+func allocateSocksPort(c *KdkEnvConfig) (string, error) {
+	registry, err := loadPortRegistry(c.ConfigRootDir(), c.PortRegistryPath())
+	if err != nil {
+		return "", err
+	}
+	return registry.allocate(c.ConfigFile.AppConfig.Name, defaultSocksPort, utils.PortAvailable)
+}
+
+// Host address on which the KDK ssh port is published
+func (c *KdkEnvConfig) BindAddress() string {
+	if c.ConfigFile.AppConfig.BindAddress == "" {
+		return defaultBindAddress
+	}
+	return c.ConfigFile.AppConfig.BindAddress
+}
+
+// check if a published port address accepts connections on all host interfaces
+func isAllInterfaces(address string) bool {
+	return address == "" || address == "0.0.0.0" || address == "::"
+}
+
+// check if an address is a loopback address
+func isLoopback(address string) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Configs created before BindAddress existed publish the ssh port on all
+// interfaces.  Binds their ssh port to loopback, or records an address that was
+// set by hand in the port binding, and returns whether the config was changed.
+func migrateBindAddress(cfg *configFile) bool {
+	if cfg.AppConfig.BindAddress != "" || cfg.HostConfig == nil {
+		return false
+	}
+	bindAddress := defaultBindAddress
+	bindings := cfg.HostConfig.PortBindings["2022/tcp"]
+	for _, binding := range bindings {
+		if binding.HostIP != "" {
+			bindAddress = binding.HostIP
+		}
+	}
+	cfg.AppConfig.BindAddress = bindAddress
+	for i := range bindings {
+		bindings[i].HostIP = bindAddress
+	}
+	return true
+}
+
+// Warns when the KDK ssh port is, or will be, published on all host interfaces
+func WarnIfExposed(cfg *KdkEnvConfig) {
+	if isAllInterfaces(cfg.BindAddress()) {
+		log.Warnf("KDK ssh port %s is published on all network interfaces and reachable from other hosts.  "+
+			"Set AppConfig.BindAddress to 127.0.0.1 in %s unless this is deliberate.", cfg.ConfigFile.AppConfig.Port, cfg.ConfigPath())
+		return
+	}
+
+	// Containers keep the port bindings they were created with
+	containerJSON, err := cfg.DockerClient.ContainerInspect(cfg.Ctx, cfg.ConfigFile.AppConfig.Name)
+	if err != nil || containerJSON.HostConfig == nil {
+		return
+	}
+	for _, binding := range containerJSON.HostConfig.PortBindings["2022/tcp"] {
+		if isAllInterfaces(binding.HostIP) {
+			log.Warnf("KDK container publishes ssh port %s on all network interfaces.  "+
+				"Run `kdk restart` to recreate it bound to %s.", binding.HostPort, cfg.BindAddress())
+			return
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+)
+
+func TestLoadPortRegistryReconcilesInstanceConfigs(t *testing.T) {
+
+	rootDir, err := ioutil.TempDir("", "kdk-ports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootDir)
+
+	registryPath := filepath.Join(rootDir, "ports.yaml")
+	stale := portRegistry{"gone": {SSH: "40000"}, "kdk1": {SSH: "40001", Socks: "8000"}}
+	if err := stale.save(registryPath); err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir(filepath.Join(rootDir, "kdk1"), 0700)
+	config := []byte("AppConfig:\n  Name: kdk1\n  Port: \"40002\"\n  SocksPort: \"8001\"\n")
+	if err := ioutil.WriteFile(filepath.Join(rootDir, "kdk1", "config.yaml"), config, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := loadPortRegistry(rootDir, registryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := registry["gone"]; ok {
+		t.Log("Registry retains an instance without a config.")
+		t.FailNow()
+	}
+	if registry["kdk1"] != (InstancePorts{SSH: "40002", Socks: "8001"}) {
+		t.Logf("Registry has %v for kdk1, expected the ports of its config.", registry["kdk1"])
+		t.FailNow()
+	}
+}
+
+func TestPortRegistryAllocateSkipsAssignedAndBusyPorts(t *testing.T) {
+
+	registry := portRegistry{
+		"kdk1": {SSH: "40000", Socks: "8000"},
+		"kdk2": {SSH: "40001", Socks: "8001"},
+	}
+	busy := func(port int) bool { return port != 8002 }
+
+	port, err := registry.allocate("kdk3", 8000, busy)
+	if err != nil || port != "8003" {
+		t.Logf("Allocated port %s (err %v), expected 8003.", port, err)
+		t.FailNow()
+	}
+
+	// An instance may keep its own ports
+	if owner := registry.owner("8000", "kdk1"); owner != "" {
+		t.Logf("Port 8000 reported as owned by %s for its own instance.", owner)
+		t.FailNow()
+	}
+}
+
+func TestMigrateBindAddress(t *testing.T) {
+
+	legacy := configFile{HostConfig: &container.HostConfig{
+		PortBindings: nat.PortMap{"2022/tcp": []nat.PortBinding{{HostPort: "40000"}}},
+	}}
+	if !migrateBindAddress(&legacy) {
+		t.Log("Legacy config was not migrated.")
+		t.FailNow()
+	}
+	if legacy.AppConfig.BindAddress != defaultBindAddress || legacy.HostConfig.PortBindings["2022/tcp"][0].HostIP != defaultBindAddress {
+		t.Logf("Legacy config bound to %v, expected %s.", legacy.HostConfig.PortBindings["2022/tcp"], defaultBindAddress)
+		t.FailNow()
+	}
+	if migrateBindAddress(&legacy) {
+		t.Log("Migrated config was migrated again.")
+		t.FailNow()
+	}
+
+	// An address set by hand in the port binding is kept
+	shared := configFile{HostConfig: &container.HostConfig{
+		PortBindings: nat.PortMap{"2022/tcp": []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "40000"}}},
+	}}
+	migrateBindAddress(&shared)
+	if shared.AppConfig.BindAddress != "0.0.0.0" {
+		t.Logf("Shared config bound to %s, expected 0.0.0.0.", shared.AppConfig.BindAddress)
+		t.FailNow()
+	}
+}
@@ -15,18 +15,71 @@
 package kdk
 
 import (
-	"github.com/codeskyblue/go-sh"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cisco-sso/kdk/pkg/prompt"
+	"github.com/cisco-sso/kdk/pkg/utils"
 	log "github.com/sirupsen/logrus"
 )
 
+// Number of trailing provisioning output lines shown on failure
+const provisionTailLines = 20
+
 func Provision(cfg KdkEnvConfig) error {
-	// TODO (rluckie): replace sh docker sdk
+	if err := provision(cfg); err != nil {
+		log.Fatal("Failed to provision KDK user.")
+		return err
+	}
+	return nil
+}
+
+// Provisions the KDK user, logging the tail of the provisioning output on failure
+func provision(cfg KdkEnvConfig) error {
 	log.Info("Starting KDK user provisioning. This may take a moment.  Hang tight...")
-	if _, err := sh.Command("docker", "exec", cfg.ConfigFile.AppConfig.Name, "/usr/local/bin/provision-user").Output(); err != nil {
-		log.WithField("error", err).Fatal("Failed to provision KDK user.")
+
+	// The full provisioning output is always saved to ~/.kdk/<KDK_NAME>/provision.log
+	logFile, err := os.Create(cfg.ProvisionLogPath())
+	if err != nil {
+		log.WithField("error", err).Error("Failed to create KDK provisioning log")
 		return err
+	}
+	defer logFile.Close()
+
+	tail := &utils.TailWriter{Size: provisionTailLines}
+	out := io.MultiWriter(logFile, tail)
+
+	// Pass the output through in debug mode, otherwise show a spinner
+	spinner := prompt.Spinner{Text: "Provisioning KDK user..."}
+	if log.IsLevelEnabled(log.DebugLevel) {
+		out = io.MultiWriter(out, HumanOutput)
 	} else {
-		log.Info("Completed KDK user provisioning.")
-		return nil
+		spinner.Start()
+	}
+
+	// Provisioning may need the proxy and CA certificates to reach the network
+	err = applyProxyAndTrust(cfg, out)
+	if err == nil {
+		var exitCode int
+		exitCode, err = containerExec(cfg, "", []string{"/usr/local/bin/provision-user"}, out, out)
+		if err == nil && exitCode != 0 {
+			err = fmt.Errorf("provision-user exited with status %d", exitCode)
+		}
+	}
+	if err == nil {
+		err = runPostProvisionHooks(cfg, out)
+	}
+	spinner.Stop()
+	if err != nil {
+		log.WithFields(log.Fields{"error": err, "log": cfg.ProvisionLogPath()}).Error("Failed to provision KDK user.")
+		if lines := tail.Lines(); len(lines) > 0 {
+			fmt.Fprintf(os.Stderr, "Last %d lines of provisioning output:\n  %s\n", len(lines), strings.Join(lines, "\n  "))
+		}
+		return err
 	}
+	log.WithField("log", cfg.ProvisionLogPath()).Info("Completed KDK user provisioning.")
+	warnIfUserIDsMismatch(cfg)
+	return nil
 }
@@ -0,0 +1,274 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+)
+
+// HTTP proxy used within the KDK.  `kdk init` detects it from the host environment.
+type Proxy struct {
+	HTTPProxy  string `json:",omitempty"`
+	HTTPSProxy string `json:",omitempty"`
+	NoProxy    string `json:",omitempty"`
+}
+
+// Certificates trusted within the KDK in addition to the system trust store
+type Trust struct {
+	CACertificates []string `json:",omitempty"` // host paths of PEM encoded CA certificates
+}
+
+const (
+	// Default hosts that bypass the proxy when NoProxy is unset
+	defaultNoProxy = "localhost,127.0.0.1"
+
+	// Container paths of proxy and trust configuration applied during provisioning
+	caCertificatesStagingDir = "/etc/kdk/ca-certificates"
+	caCertificatesDir        = "/usr/local/share/ca-certificates/kdk"
+	proxyStagingDir          = "/etc/kdk/proxy"
+	dockerProxyDropIn        = "/etc/systemd/system/docker.service.d/kdk-proxy.conf"
+	profileProxyScript       = "/etc/profile.d/kdk-proxy.sh"
+	aptProxyConfig           = "/etc/apt/apt.conf.d/80kdk-proxy"
+
+	// Container group of the KDK user, which alone may read proxy config that can hold credentials
+	proxyGroup = "kdk-proxy"
+)
+
+// Returns the proxy configured in the host environment, or nil if there is none
+func detectProxy() *Proxy {
+	proxy := Proxy{
+		HTTPProxy:  firstEnv("HTTP_PROXY", "http_proxy"),
+		HTTPSProxy: firstEnv("HTTPS_PROXY", "https_proxy"),
+		NoProxy:    firstEnv("NO_PROXY", "no_proxy"),
+	}
+	if proxy.HTTPProxy == "" && proxy.HTTPSProxy == "" {
+		return nil
+	}
+	return &proxy
+}
+
+func firstEnv(keys ...string) string {
+	for _, key := range keys {
+		if value := os.Getenv(key); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Returns the proxy settings as seen from within the container.  A proxy on
+// the host loopback interface is reached through the host gateway.
+func (c *KdkEnvConfig) containerProxy() *Proxy {
+	if c.ConfigFile.AppConfig.Proxy == nil {
+		return nil
+	}
+	proxy := *c.ConfigFile.AppConfig.Proxy
+	proxy.HTTPProxy = proxyThroughGateway(proxy.HTTPProxy, c.HostGateway())
+	proxy.HTTPSProxy = proxyThroughGateway(proxy.HTTPSProxy, c.HostGateway())
+	if proxy.NoProxy == "" {
+		proxy.NoProxy = defaultNoProxy
+	}
+	return &proxy
+}
+
+func proxyThroughGateway(proxyURL, gateway string) string {
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.Host == "" {
+		return proxyURL
+	}
+	hostname := u.Hostname()
+	if ip := net.ParseIP(hostname); hostname != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return proxyURL
+	}
+	if port := u.Port(); port != "" {
+		u.Host = net.JoinHostPort(gateway, port)
+	} else {
+		u.Host = gateway
+	}
+	return u.String()
+}
+
+// Proxy environment variables in both the upper and lower case conventions
+func (p *Proxy) env() []string {
+	var env []string
+	for _, variable := range []struct{ key, value string }{
+		{"HTTP_PROXY", p.HTTPProxy},
+		{"HTTPS_PROXY", p.HTTPSProxy},
+		{"NO_PROXY", p.NoProxy},
+	} {
+		if variable.value != "" {
+			env = append(env, variable.key+"="+variable.value, strings.ToLower(variable.key)+"="+variable.value)
+		}
+	}
+	return env
+}
+
+// Returns a proxy function for HTTP clients on the host that honours the proxy settings
+func (p *Proxy) proxyFunc() func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		proxyURL := p.HTTPProxy
+		if req.URL.Scheme == "https" {
+			proxyURL = p.HTTPSProxy
+		}
+		if proxyURL == "" || bypassesProxy(req.URL.Hostname(), p.NoProxy) {
+			return nil, nil
+		}
+		if !strings.Contains(proxyURL, "://") {
+			proxyURL = "http://" + proxyURL
+		}
+		return url.Parse(proxyURL)
+	}
+}
+
+// check if a host is excluded from proxying by a NO_PROXY list
+func bypassesProxy(host, noProxy string) bool {
+	if noProxy == "" {
+		noProxy = defaultNoProxy
+	}
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "*" {
+			return true
+		}
+		entry = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+		if entry != "" && (host == entry || strings.HasSuffix(host, "."+entry)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads the configured CA certificates, keyed by their file name in the container trust store
+func (c *KdkEnvConfig) caCertificates() ([]containerFile, error) {
+	if c.ConfigFile.AppConfig.Trust == nil {
+		return nil, nil
+	}
+	var files []containerFile
+	for i, certPath := range c.ConfigFile.AppConfig.Trust.CACertificates {
+		path, err := homedir.Expand(certPath)
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if block, _ := pem.Decode(content); block == nil || block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("CA certificate [%s] is not a PEM encoded certificate", certPath)
+		}
+		// update-ca-certificates only picks up .crt files
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		files = append(files, containerFile{
+			Path:    fmt.Sprintf("%s/%02d-%s.crt", caCertificatesStagingDir, i, name),
+			Content: content,
+			Mode:    0644,
+		})
+	}
+	return files, nil
+}
+
+// Installs the configured CA certificates into the container trust store and
+// configures the proxy for login shells, apt and the nested dockerd.  Runs on
+// every start so that config changes apply without recreating the container.
+// The nested dockerd is only restarted when its proxy or trust store changed.
+func applyProxyAndTrust(cfg KdkEnvConfig, out io.Writer) error {
+	certificates, err := cfg.caCertificates()
+	if err != nil {
+		return err
+	}
+	proxy := cfg.containerProxy()
+
+	files := certificates
+	if proxy != nil {
+		var dropIn, profile, apt strings.Builder
+		dropIn.WriteString("[Service]\n")
+		for _, variable := range proxy.env() {
+			fmt.Fprintf(&dropIn, "Environment=\"%s\"\n", variable)
+			fmt.Fprintf(&profile, "export '%s'\n", strings.Replace(variable, "'", `'\''`, -1))
+		}
+		if proxy.HTTPProxy != "" {
+			fmt.Fprintf(&apt, "Acquire::http::Proxy \"%s\";\n", proxy.HTTPProxy)
+		}
+		if proxy.HTTPSProxy != "" {
+			fmt.Fprintf(&apt, "Acquire::https::Proxy \"%s\";\n", proxy.HTTPSProxy)
+		}
+		files = append(files,
+			containerFile{Path: proxyStagingDir + "/docker.conf", Content: []byte(dropIn.String()), Mode: 0600},
+			containerFile{Path: proxyStagingDir + "/profile.sh", Content: []byte(profile.String()), Mode: 0600},
+			containerFile{Path: proxyStagingDir + "/apt.conf", Content: []byte(apt.String()), Mode: 0600},
+		)
+	}
+
+	// Staged files are replaced as a whole so that removed certificates and proxies are removed
+	if exitCode, err := containerExec(cfg, "", []string{"rm", "-rf", caCertificatesStagingDir, proxyStagingDir}, out, out); err != nil {
+		return err
+	} else if exitCode != 0 {
+		return fmt.Errorf("failed to clear staged proxy and trust config")
+	}
+	if len(files) > 0 {
+		if err := copyToContainer(cfg, cfg.ConfigFile.AppConfig.Name, files...); err != nil {
+			return err
+		}
+	}
+
+	script := fmt.Sprintf(`set -e
+bundle_before=$(cat /etc/ssl/certs/ca-certificates.crt 2>/dev/null | sha256sum)
+rm -rf %[1]s
+if [ -d %[2]s ]; then cp -r %[2]s %[1]s; fi
+update-ca-certificates >/dev/null
+bundle_after=$(cat /etc/ssl/certs/ca-certificates.crt 2>/dev/null | sha256sum)
+
+# Proxy URLs may hold credentials, so only root and the KDK user may read them
+getent group %[7]s >/dev/null || groupadd -r %[7]s
+if [ -n "$KDK_USERNAME" ] && getent passwd "$KDK_USERNAME" >/dev/null; then usermod -a -G %[7]s "$KDK_USERNAME"; fi
+install_staged() { if [ -f "$1" ]; then mkdir -p "$(dirname "$2")"; install -m "$3" -g "$4" "$1" "$2"; else rm -f "$2"; fi; }
+install_staged %[3]s/profile.sh %[4]s 0640 %[7]s
+install_staged %[3]s/apt.conf %[5]s 0640 %[7]s
+
+if [ -f %[3]s/docker.conf ] || [ -f %[6]s ]; then
+  if ! cmp -s %[3]s/docker.conf %[6]s; then
+    install_staged %[3]s/docker.conf %[6]s 0600 root
+    docker_changed=1
+  fi
+fi
+if [ -n "$docker_changed" ] || [ "$bundle_before" != "$bundle_after" ]; then
+  echo "Restarting docker to apply proxy and trust config"
+  systemctl daemon-reload
+  systemctl restart docker
+fi
+`, caCertificatesDir, caCertificatesStagingDir, proxyStagingDir, profileProxyScript, aptProxyConfig, dockerProxyDropIn, proxyGroup)
+
+	log.WithFields(log.Fields{"proxy": proxy != nil, "certificates": len(certificates)}).Debug("Applying KDK proxy and trust config")
+	exitCode, err := containerExec(cfg, "", []string{"sh", "-c", script}, out, out)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("applying proxy and trust config exited with status %d", exitCode)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestContainerProxyReachesHostLoopbackThroughGateway(t *testing.T) {
+
+	cfg := KdkEnvConfig{engine: EngineDocker}
+	cfg.ConfigFile.AppConfig.Proxy = &Proxy{
+		HTTPProxy:  "http://127.0.0.1:3128",
+		HTTPSProxy: "http://proxy.corp.example.com:8080",
+	}
+
+	proxy := cfg.containerProxy()
+
+	expected := []string{
+		"HTTP_PROXY=http://host.docker.internal:3128",
+		"http_proxy=http://host.docker.internal:3128",
+		"HTTPS_PROXY=http://proxy.corp.example.com:8080",
+		"https_proxy=http://proxy.corp.example.com:8080",
+		"NO_PROXY=" + defaultNoProxy,
+		"no_proxy=" + defaultNoProxy,
+	}
+	if !reflect.DeepEqual(proxy.env(), expected) {
+		t.Logf("Proxy env is %v, expected %v.", proxy.env(), expected)
+		t.FailNow()
+	}
+	if cfg.ConfigFile.AppConfig.Proxy.HTTPProxy != "http://127.0.0.1:3128" {
+		t.Log("containerProxy modified the persisted config.")
+		t.FailNow()
+	}
+}
+
+func TestProxyFuncHonoursNoProxy(t *testing.T) {
+
+	proxy := Proxy{HTTPSProxy: "proxy.corp.example.com:8080", NoProxy: "localhost,.internal.example.com"}
+	proxyFunc := proxy.proxyFunc()
+
+	for target, expected := range map[string]string{
+		"https://github.com/cisco-sso/kdk":             "http://proxy.corp.example.com:8080",
+		"https://releases.internal.example.com/kdk":    "",
+		"https://localhost:8443/kdk":                   "",
+		"http://github.com/cisco-sso/kdk":              "",
+		"https://notinternal.example.com/kdk/releases": "http://proxy.corp.example.com:8080",
+	} {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		proxyURL, err := proxyFunc(req)
+		actual := ""
+		if proxyURL != nil {
+			actual = proxyURL.String()
+		}
+		if err != nil || actual != expected {
+			t.Logf("Proxy for %s is %q (%v), expected %q.", target, actual, err, expected)
+			t.FailNow()
+		}
+	}
+}
@@ -23,9 +23,10 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func Prune(cfg KdkEnvConfig) error {
+func Prune(cfg KdkEnvConfig) (result PruneResult, err error) {
 	log.Info("Starting Prune...")
 
+	result.Deleted = []string{}
 	var (
 		imageIds                 []string
 		runningContainerImageIds []string
@@ -78,19 +79,20 @@ func Prune(cfg KdkEnvConfig) error {
 				Loop:     true,
 				Validate: prompt.ValidateYorN,
 			}
-			if result, err := prmpt.Run(); err != nil || result == "n" {
+			if answer, err := prmpt.Run(); err != nil || answer == "n" {
 				log.Error("KDK stale image deletion canceled or invalid input.")
-				return err
+				return result, err
 			}
 			if _, err := cfg.DockerClient.ImageRemove(cfg.Ctx, targetImage, types.ImageRemoveOptions{Force: true, PruneChildren: true}); err != nil {
 				log.WithField("error", err).Fatalf("Failed to prune KDK image [%s]", targetImage)
-				return err
+				return result, err
 			} else {
 				log.Infof("Deleted stale KDK image [%s]", targetImage)
+				result.Deleted = append(result.Deleted, targetImage)
 			}
 		}
 	} else {
 		log.Infof("No stale KDK images to delete")
 	}
-	return nil
+	return result, nil
 }
@@ -19,7 +19,6 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/pkg/jsonmessage"
 	log "github.com/sirupsen/logrus"
-	"os"
 )
 
 type ProgressDetail struct {
@@ -57,6 +56,6 @@ func pullImage(cfg *KdkEnvConfig, imageCoordinates string) error {
 	}
 	defer responseBody.Close()
 
-	outStream := command.NewOutStream(os.Stdout)
+	outStream := command.NewOutStream(HumanOutput)
 	return jsonmessage.DisplayJSONMessagesToStream(responseBody, outStream, nil)
 }
@@ -0,0 +1,183 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/codeskyblue/go-sh"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Default deadline for the KDK to become ready when AppConfig.ReadyTimeout is unset
+	defaultReadyTimeout = 2 * time.Minute
+
+	// Bounds of the exponential backoff between readiness probes
+	readyInitialBackoff = 250 * time.Millisecond
+	readyMaxBackoff     = 5 * time.Second
+
+	// Timeout of a single readiness probe connection
+	readyProbeTimeout = 5 * time.Second
+)
+
+// A stage of KDK startup along with the probe that succeeds once it is ready
+type readinessStage struct {
+	name  string
+	probe func() error
+}
+
+// Blocks until the KDK container is running, sshd accepts connections and the
+// KDK user is provisioned, or the configured deadline passes.
+func (c *KdkEnvConfig) WaitReady() error {
+	timeout := c.readyTimeout()
+	deadline := time.Now().Add(timeout)
+
+	stages := []readinessStage{
+		{name: "container", probe: c.probeContainer},
+		{name: "sshd", probe: c.probeSSHD},
+		{name: "provisioning", probe: c.probeSSHLogin},
+	}
+	// Behind a jump host only the ssh command can reach the KDK, so login covers both sshd and provisioning
+	if c.SSHJumpHost() != "" {
+		stages = []readinessStage{
+			{name: "container", probe: c.probeContainer},
+			{name: "sshd and provisioning", probe: c.probeSSHCommand},
+		}
+	}
+	for _, stage := range stages {
+		log.WithField("stage", stage.name).Debug("Waiting for KDK readiness")
+		if err := waitFor(stage.probe, deadline, readyInitialBackoff, readyMaxBackoff); err != nil {
+			return fmt.Errorf("timed out after %s waiting for KDK %s to become ready: %v", timeout, stage.name, err)
+		}
+	}
+	return nil
+}
+
+// Polls probe with exponential backoff until it succeeds or the deadline
+// passes, returning the last probe error on timeout.
+func waitFor(probe func() error, deadline time.Time, backoff, maxBackoff time.Duration) error {
+	for {
+		err := probe()
+		if err == nil {
+			return nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return err
+		}
+		if backoff > remaining {
+			backoff = remaining
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (c *KdkEnvConfig) readyTimeout() time.Duration {
+	if c.ConfigFile.AppConfig.ReadyTimeout == "" {
+		return defaultReadyTimeout
+	}
+	timeout, err := time.ParseDuration(c.ConfigFile.AppConfig.ReadyTimeout)
+	if err != nil {
+		log.WithField("error", err).Warnf("Invalid ReadyTimeout; using default of %s", defaultReadyTimeout)
+		return defaultReadyTimeout
+	}
+	return timeout
+}
+
+// The container is running, and healthy if the image defines a HEALTHCHECK
+func (c *KdkEnvConfig) probeContainer() error {
+	containerJSON, err := c.DockerClient.ContainerInspect(c.Ctx, c.ConfigFile.AppConfig.Name)
+	if err != nil {
+		return err
+	}
+	state := containerJSON.State
+	if state == nil || !state.Running {
+		return errors.New("container is not running")
+	}
+	if state.Health != nil && state.Health.Status != "healthy" {
+		return fmt.Errorf("container health is %s", state.Health.Status)
+	}
+	return nil
+}
+
+// sshd answers with its protocol version banner.  A bare TCP connection is not
+// enough since the docker port proxy accepts connections before sshd listens.
+func (c *KdkEnvConfig) probeSSHD() error {
+	conn, err := net.DialTimeout("tcp", c.SSHAddress(), readyProbeTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(readyProbeTimeout))
+
+	banner, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("no ssh banner: %v", err)
+	}
+	if !strings.HasPrefix(banner, "SSH-") {
+		return fmt.Errorf("unexpected ssh banner %q", strings.TrimSpace(banner))
+	}
+	return nil
+}
+
+// Runs a no-op command through the ssh command line, which honours the jump host
+func (c *KdkEnvConfig) probeSSHCommand() error {
+	commandString := fmt.Sprintf("%s -o BatchMode=yes -o ConnectTimeout=%d true", c.SSHCommandString(), int(readyProbeTimeout.Seconds()))
+	commandMap := strings.Split(commandString, " ")
+	if out, err := sh.Command(commandMap[0], commandMap[1:]).CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// The KDK user can log in with the KDK key, which is only authorized once
+// provisioning has installed it
+func (c *KdkEnvConfig) probeSSHLogin() error {
+	keyBytes, err := ioutil.ReadFile(c.PrivateKeyPath())
+	if err != nil {
+		return err
+	}
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	if err != nil {
+		return err
+	}
+	// Another listener on the KDK port must not pass for the KDK
+	hostKey, err := c.hostPublicKey()
+	if err != nil {
+		return err
+	}
+	client, err := ssh.Dial("tcp", c.SSHAddress(), &ssh.ClientConfig{
+		User:              c.User(),
+		Auth:              []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback:   ssh.FixedHostKey(hostKey),
+		HostKeyAlgorithms: []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256},
+		Timeout:           readyProbeTimeout,
+	})
+	if err != nil {
+		return err
+	}
+	return client.Close()
+}
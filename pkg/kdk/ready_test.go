@@ -0,0 +1,140 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	kdkssh "github.com/cisco-sso/kdk/pkg/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestWaitForSucceedsAfterRetries(t *testing.T) {
+
+	attempts := 0
+	probe := func() error {
+		if attempts++; attempts < 3 {
+			return errors.New("not ready")
+		}
+		return nil
+	}
+	err := waitFor(probe, time.Now().Add(time.Second), time.Millisecond, 2*time.Millisecond)
+	if err != nil || attempts != 3 {
+		t.Logf("waitFor returned %v after %d attempts, expected success after 3.", err, attempts)
+		t.FailNow()
+	}
+}
+
+func TestWaitForReturnsLastErrorAtDeadline(t *testing.T) {
+
+	probe := func() error {
+		return errors.New("sshd not listening")
+	}
+	err := waitFor(probe, time.Now().Add(20*time.Millisecond), time.Millisecond, 5*time.Millisecond)
+	if err == nil || err.Error() != "sshd not listening" {
+		t.Logf("waitFor returned %v, expected the last probe error.", err)
+		t.FailNow()
+	}
+}
+
+// Serves ssh logins with the host key on a local port, returning the port
+func serveSSH(t *testing.T, hostKey ssh.Signer) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, channels, requests, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(requests)
+				for channel := range channels {
+					channel.Reject(ssh.Prohibited, "no channels")
+				}
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port
+}
+
+func TestProbeSSHLoginVerifiesPinnedHostKey(t *testing.T) {
+	defer useTempHome(t)()
+
+	cfg := KdkEnvConfig{}
+	cfg.ConfigFile.AppConfig.Name = "kdk"
+	cfg.ConfigFile.AppConfig.Host = &Host{Address: "127.0.0.1"}
+	for _, dir := range []string{cfg.ConfigDir(), cfg.KeypairDir()} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cfg.CreateKdkHostKey(); err != nil {
+		t.Fatal(err)
+	}
+	userKey, err := kdkssh.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kdkssh.WriteKeyToFile(kdkssh.EncodePrivateKey(userKey), cfg.PrivateKeyPath()); err != nil {
+		t.Fatal(err)
+	}
+
+	hostKeyBytes, err := ioutil.ReadFile(cfg.HostKeyPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.ParsePrivateKey(hostKeyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ConfigFile.AppConfig.Port = serveSSH(t, hostKey)
+	if err := cfg.probeSSHLogin(); err != nil {
+		t.Logf("Login to sshd presenting the pinned RSA host key failed: %v", err)
+		t.FailNow()
+	}
+
+	otherKey, err := kdkssh.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSigner, err := ssh.NewSignerFromKey(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ConfigFile.AppConfig.Port = serveSSH(t, otherSigner)
+	if err := cfg.probeSSHLogin(); err == nil {
+		t.Log("Login to sshd presenting another host key succeeded.")
+		t.FailNow()
+	}
+}
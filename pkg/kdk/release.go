@@ -0,0 +1,145 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cisco-sso/kdk/pkg/minisign"
+	log "github.com/sirupsen/logrus"
+)
+
+// Minisign public key with which official release checksum manifests are signed
+const releasePublicKey = "RWQ/qaQ2obqKlIW+nCsLsCg7ieDVyRKM4bg9FRANGTt0pm4uKSS+Fhi4"
+
+// Release public key of builds from forks that sign their own releases, set with
+// -ldflags "-X github.com/cisco-sso/kdk/pkg/kdk.ReleasePublicKey=<key>".
+// An empty value keeps the official key.
+var ReleasePublicKey = ""
+
+// Returns the key with which release checksum manifests must be signed
+func pinnedReleasePublicKey() string {
+	if ReleasePublicKey != "" {
+		return ReleasePublicKey
+	}
+	return releasePublicKey
+}
+
+const (
+	// Release checksums manifest (sha256sum format) and its detached minisign signature
+	releaseChecksumsName = "checksums.txt"
+	releaseSignatureName = "checksums.txt.minisig"
+
+	// Upper bound of the size of the checksums manifest and signature
+	maxReleaseManifestSize = 1 << 20
+)
+
+// Name of the release archive of a version for a platform
+func releaseArchiveName(version, goos, goarch string) string {
+	return "kdk-" + version + "-" + goos + "-" + goarch + ".tar.gz"
+}
+
+// Downloads a release archive from the source into dir.  The checksums
+// manifest must be signed with publicKey and the archive must match its
+// checksum in the manifest; otherwise nothing is left in dir.  Returns the
+// archive path.
+func downloadVerifiedRelease(source releaseSource, version, archiveName, dir, publicKey string) (string, error) {
+	if publicKey == "" {
+		return "", errors.New("this kdk build has no release public key to verify updates with; install kdk from an official release")
+	}
+	key, err := minisign.ParsePublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	checksums, err := readReleaseFile(source, version, releaseChecksumsName, maxReleaseManifestSize)
+	if err != nil {
+		return "", err
+	}
+	signatureData, err := readReleaseFile(source, version, releaseSignatureName, maxReleaseManifestSize)
+	if err != nil {
+		return "", err
+	}
+	signature, err := minisign.ParseSignature(signatureData)
+	if err != nil {
+		return "", err
+	}
+	if err := key.Verify(checksums, signature); err != nil {
+		return "", fmt.Errorf("release checksums failed signature verification: %v", err)
+	}
+	log.WithField("trustedComment", signature.TrustedComment).Info("Verified release checksums signature")
+
+	expected, err := releaseChecksum(checksums, archiveName)
+	if err != nil {
+		return "", err
+	}
+
+	archivePath := filepath.Join(dir, archiveName)
+	actual, err := downloadWithChecksum(source, version, archiveName, archivePath)
+	if err == nil && actual != expected {
+		err = fmt.Errorf("checksum mismatch for %s: expected %s, got %s", archiveName, expected, actual)
+	}
+	if err != nil {
+		os.Remove(archivePath)
+		return "", err
+	}
+	log.WithFields(log.Fields{"file": archivePath, "sha256": actual}).Info("Verified release archive checksum")
+	return archivePath, nil
+}
+
+// Returns the checksum of a file in a sha256sum formatted manifest
+func releaseChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("release checksums have no entry for %s", name)
+}
+
+// Downloads a release file to file, returning the hex encoded SHA-256 of the content
+func downloadWithChecksum(source releaseSource, version, name, file string) (string, error) {
+	body, err := source.open(version, name)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	fd, err := os.Create(file)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(fd, hash), body); err != nil {
+		return "", err
+	}
+	if err := fd.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,129 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cisco-sso/kdk/pkg/minisign"
+)
+
+// Serves a signed release the way the release download site does
+func releaseServer(t *testing.T, files map[string][]byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[filepath.Base(r.URL.Path)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+	}))
+}
+
+func TestDownloadVerifiedRelease(t *testing.T) {
+
+	key, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID := [8]byte{0xc1, 0x5c, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1}
+	publicKey := minisign.EncodePublicKey(keyID, key)
+
+	archiveName := releaseArchiveName("1.2.3", "linux", "amd64")
+	archive := []byte("release archive")
+	sum := sha256.Sum256(archive)
+	checksums := []byte(hex.EncodeToString(sum[:]) + "  " + archiveName + "\n")
+
+	for _, tc := range []struct {
+		name      string
+		files     map[string][]byte
+		publicKey string
+		ok        bool
+	}{
+		{"verified", map[string][]byte{
+			releaseChecksumsName: checksums,
+			releaseSignatureName: minisign.Sign(privateKey, keyID, checksums, "kdk 1.2.3"),
+			archiveName:          archive,
+		}, publicKey, true},
+		{"tampered archive", map[string][]byte{
+			releaseChecksumsName: checksums,
+			releaseSignatureName: minisign.Sign(privateKey, keyID, checksums, "kdk 1.2.3"),
+			archiveName:          []byte("tampered archive"),
+		}, publicKey, false},
+		{"tampered checksums", map[string][]byte{
+			releaseChecksumsName: []byte("0000  " + archiveName + "\n"),
+			releaseSignatureName: minisign.Sign(privateKey, keyID, checksums, "kdk 1.2.3"),
+			archiveName:          archive,
+		}, publicKey, false},
+		{"missing signature", map[string][]byte{
+			releaseChecksumsName: checksums,
+			archiveName:          archive,
+		}, publicKey, false},
+		{"no public key", map[string][]byte{
+			releaseChecksumsName: checksums,
+			releaseSignatureName: minisign.Sign(privateKey, keyID, checksums, "kdk 1.2.3"),
+			archiveName:          archive,
+		}, "", false},
+	} {
+		server := releaseServer(t, tc.files)
+		dir, err := ioutil.TempDir("", "kdk-release")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		archivePath, err := downloadVerifiedRelease(&httpSource{url: server.URL}, "1.2.3", archiveName, dir, tc.publicKey)
+		leftover, _ := ioutil.ReadDir(dir)
+		server.Close()
+		os.RemoveAll(dir)
+
+		if tc.ok && (err != nil || archivePath != filepath.Join(dir, archiveName)) {
+			t.Logf("%s: download failed: %v", tc.name, err)
+			t.FailNow()
+		}
+		if !tc.ok && (err == nil || len(leftover) != 0) {
+			t.Logf("%s: download succeeded or left %d files behind.", tc.name, len(leftover))
+			t.FailNow()
+		}
+	}
+}
+
+func TestPinnedReleasePublicKey(t *testing.T) {
+
+	if _, err := minisign.ParsePublicKey(releasePublicKey); err != nil {
+		t.Logf("Official release public key does not parse: %v", err)
+		t.FailNow()
+	}
+
+	defer func(previous string) { ReleasePublicKey = previous }(ReleasePublicKey)
+	ReleasePublicKey = ""
+	if pinnedReleasePublicKey() != releasePublicKey {
+		t.Log("An empty release public key override replaces the official key.")
+		t.FailNow()
+	}
+	ReleasePublicKey = "RWQfork"
+	if pinnedReleasePublicKey() != "RWQfork" {
+		t.Log("The release public key override is ignored.")
+		t.FailNow()
+	}
+}
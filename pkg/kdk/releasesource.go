@@ -0,0 +1,289 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Kinds of release sources
+const (
+	SourceGitHub = "github"
+	SourceHTTP   = "http"
+	SourceDir    = "dir"
+)
+
+var SourceTypes = []string{SourceGitHub, SourceHTTP, SourceDir}
+
+// Where `kdk update` finds releases.  All fields are optional; releases are
+// fetched from github.com by default.  HTTP and directory sources hold an
+// index.json mapping each channel to its latest version (e.g.
+// {"stable": "1.2.3", "prerelease": "1.3.0-rc.1"}) and the release files
+// under <version>/.
+type ReleaseSource struct {
+	Type       string `json:",omitempty"` // github | http | dir
+	URL        string `json:",omitempty"` // GitHub Enterprise URL, artifact server URL or local directory
+	Repository string `json:",omitempty"` // GitHub repository (owner/name); defaults to cisco-sso/kdk
+	TokenEnv   string `json:",omitempty"` // environment variable holding the auth token; defaults to KDK_RELEASE_TOKEN
+}
+
+const (
+	defaultReleaseRepository = "cisco-sso/kdk"
+	defaultReleaseTokenEnv   = "KDK_RELEASE_TOKEN"
+	releaseIndexName         = "index.json"
+
+	// Timeouts of the latest version check and of release file downloads
+	releaseCheckTimeout    = 3 * time.Second
+	releaseDownloadTimeout = 10 * time.Minute
+)
+
+// Published kdk releases
+type releaseSource interface {
+	// Returns the latest release version of a channel
+	latestVersion(channel string) (string, error)
+	// Opens a file of a release
+	open(version, name string) (io.ReadCloser, error)
+}
+
+// Returns the configured release source
+func (c *KdkEnvConfig) releaseSource() (releaseSource, error) {
+	source := ReleaseSource{}
+	if settings := c.updateSettings(); settings.Source != nil {
+		source = *settings.Source
+	}
+	tokenEnv := source.TokenEnv
+	if tokenEnv == "" {
+		tokenEnv = defaultReleaseTokenEnv
+	}
+	client := releaseHTTP{token: os.Getenv(tokenEnv), proxy: http.ProxyFromEnvironment}
+	if c.ConfigFile.AppConfig.Proxy != nil {
+		client.proxy = c.ConfigFile.AppConfig.Proxy.proxyFunc()
+	}
+
+	switch source.Type {
+	case "", SourceGitHub:
+		repository := source.Repository
+		if repository == "" {
+			repository = defaultReleaseRepository
+		}
+		apiURL := "https://api.github.com"
+		if source.URL != "" {
+			apiURL = strings.TrimSuffix(source.URL, "/") + "/api/v3"
+		}
+		client.authScheme = "token"
+		return &githubSource{releaseHTTP: client, apiURL: apiURL, repository: repository}, nil
+	case SourceHTTP:
+		if source.URL == "" {
+			return nil, errors.New("release source [http] requires Update.Source.URL")
+		}
+		client.authScheme = "Bearer"
+		return &httpSource{releaseHTTP: client, url: strings.TrimSuffix(source.URL, "/")}, nil
+	case SourceDir:
+		if source.URL == "" {
+			return nil, errors.New("release source [dir] requires Update.Source.URL")
+		}
+		return &dirSource{dir: source.URL}, nil
+	}
+	return nil, fmt.Errorf("unknown release source [%s]; expected one of %s", source.Type, strings.Join(SourceTypes, "|"))
+}
+
+// HTTP access to a release source
+type releaseHTTP struct {
+	token      string
+	authScheme string
+	proxy      func(*http.Request) (*url.URL, error)
+}
+
+// Gets url, failing unless the response is 200 OK.  The auth token is not
+// forwarded when redirected to another host.
+func (h releaseHTTP) get(url, accept string, timeout time.Duration) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if h.token != "" {
+		req.Header.Set("Authorization", h.authScheme+" "+h.token)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = h.proxy
+	resp, err := (&http.Client{Timeout: timeout, Transport: transport}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// Releases of a github.com or GitHub Enterprise repository
+type githubSource struct {
+	releaseHTTP
+	apiURL     string
+	repository string
+	releases   map[string]githubRelease // by tag, fetched at most once
+}
+
+type githubRelease struct {
+	TagName string        `json:"tag_name"`
+	Assets  []githubAsset `json:"assets"`
+}
+
+type githubAsset struct {
+	Name               string `json:"name"`
+	URL                string `json:"url"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+}
+
+func (s *githubSource) getJSON(path string, v interface{}) error {
+	body, err := s.get(s.apiURL+"/repos/"+s.repository+path, "application/vnd.github.v3+json", releaseCheckTimeout)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return json.NewDecoder(body).Decode(v)
+}
+
+func (s *githubSource) latestVersion(channel string) (string, error) {
+	// The latest release excludes prereleases, while the release list is newest first and includes them
+	var release githubRelease
+	if channel == ChannelPrerelease {
+		var releases []githubRelease
+		if err := s.getJSON("/releases?per_page=1", &releases); err != nil {
+			return "", err
+		}
+		if len(releases) == 0 {
+			return "", fmt.Errorf("repository %s has no releases", s.repository)
+		}
+		release = releases[0]
+	} else if err := s.getJSON("/releases/latest", &release); err != nil {
+		return "", err
+	}
+	return release.TagName, nil
+}
+
+func (s *githubSource) open(version, name string) (io.ReadCloser, error) {
+	release, ok := s.releases[version]
+	if !ok {
+		if err := s.getJSON("/releases/tags/"+url.PathEscape(version), &release); err != nil {
+			return nil, err
+		}
+		if s.releases == nil {
+			s.releases = map[string]githubRelease{}
+		}
+		s.releases[version] = release
+	}
+	for _, asset := range release.Assets {
+		if asset.Name != name {
+			continue
+		}
+		// Assets of private repositories are only served to token holders through the API
+		if s.token != "" {
+			return s.get(asset.URL, "application/octet-stream", releaseDownloadTimeout)
+		}
+		return s.get(asset.BrowserDownloadURL, "", releaseDownloadTimeout)
+	}
+	return nil, fmt.Errorf("release %s of %s has no file %s", version, s.repository, name)
+}
+
+// Latest release version of each channel.  Channels without an entry follow stable.
+type releaseIndex map[string]string
+
+func parseReleaseIndex(body io.Reader, channel string) (string, error) {
+	index := releaseIndex{}
+	if err := json.NewDecoder(body).Decode(&index); err != nil {
+		return "", fmt.Errorf("invalid release index: %v", err)
+	}
+	if version := index[channel]; version != "" {
+		return version, nil
+	}
+	if version := index[ChannelStable]; version != "" {
+		return version, nil
+	}
+	return "", fmt.Errorf("release index has no %s release", channel)
+}
+
+// Releases on a generic HTTP artifact server
+type httpSource struct {
+	releaseHTTP
+	url string
+}
+
+func (s *httpSource) latestVersion(channel string) (string, error) {
+	body, err := s.get(s.url+"/"+releaseIndexName, "application/json", releaseCheckTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	return parseReleaseIndex(body, channel)
+}
+
+func (s *httpSource) open(version, name string) (io.ReadCloser, error) {
+	return s.get(s.url+"/"+url.PathEscape(version)+"/"+url.PathEscape(name), "", releaseDownloadTimeout)
+}
+
+// Releases in a local (or network mounted) directory
+type dirSource struct {
+	dir string
+}
+
+func (s *dirSource) latestVersion(channel string) (string, error) {
+	body, err := os.Open(filepath.Join(s.dir, releaseIndexName))
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	return parseReleaseIndex(body, channel)
+}
+
+func (s *dirSource) open(version, name string) (io.ReadCloser, error) {
+	for _, element := range []string{version, name} {
+		if element == "" || element == "." || element == ".." || strings.ContainsAny(element, `/\`) {
+			return nil, fmt.Errorf("invalid release file %s/%s", version, name)
+		}
+	}
+	return os.Open(filepath.Join(s.dir, version, name))
+}
+
+// Reads a release file of at most limit bytes
+func readReleaseFile(source releaseSource, version, name string, limit int64) ([]byte, error) {
+	body, err := source.open(version, name)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s exceeds %d bytes", name, limit)
+	}
+	return data, nil
+}
@@ -0,0 +1,118 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGithubSource(t *testing.T) {
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asset := githubAsset{Name: "checksums.txt", URL: server.URL + "/api/v3/assets/1", BrowserDownloadURL: server.URL + "/download/checksums.txt"}
+		switch r.URL.Path {
+		case "/api/v3/repos/org/kdk/releases/latest":
+			json.NewEncoder(w).Encode(githubRelease{TagName: "1.2.3"})
+		case "/api/v3/repos/org/kdk/releases":
+			json.NewEncoder(w).Encode([]githubRelease{{TagName: "1.3.0-rc.1"}})
+		case "/api/v3/repos/org/kdk/releases/tags/1.2.3":
+			json.NewEncoder(w).Encode(githubRelease{TagName: "1.2.3", Assets: []githubAsset{asset}})
+		case "/api/v3/assets/1":
+			if r.Header.Get("Authorization") != "token secret" || r.Header.Get("Accept") != "application/octet-stream" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("private"))
+		case "/download/checksums.txt":
+			w.Write([]byte("public"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cfg := KdkEnvConfig{}
+	cfg.ConfigFile.AppConfig.Update = &UpdateSettings{Source: &ReleaseSource{URL: server.URL + "/", Repository: "org/kdk"}}
+	source, err := cfg.releaseSource()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for channel, expected := range map[string]string{ChannelStable: "1.2.3", ChannelPrerelease: "1.3.0-rc.1"} {
+		if version, err := source.latestVersion(channel); err != nil || version != expected {
+			t.Logf("Latest %s version is %q (%v), expected %s.", channel, version, err, expected)
+			t.FailNow()
+		}
+	}
+
+	// Assets are downloaded through the API only when there is a token
+	for token, expected := range map[string]string{"": "public", "secret": "private"} {
+		source.(*githubSource).token = token
+		content, err := readReleaseFile(source, "1.2.3", "checksums.txt", maxReleaseManifestSize)
+		if err != nil || string(content) != expected {
+			t.Logf("Release file is %q (%v), expected %q.", content, err, expected)
+			t.FailNow()
+		}
+	}
+	if _, err := source.open("1.2.3", "missing.tar.gz"); err == nil {
+		t.Log("Opening a file missing from the release succeeded.")
+		t.FailNow()
+	}
+}
+
+func TestDirSource(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "kdk-releases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, releaseIndexName), []byte(`{"stable": "1.2.3"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "1.2.3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "1.2.3", releaseChecksumsName), []byte("checksums"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := KdkEnvConfig{}
+	cfg.ConfigFile.AppConfig.Update = &UpdateSettings{Source: &ReleaseSource{Type: SourceDir, URL: dir}}
+	source, err := cfg.releaseSource()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Channels missing from the index follow stable
+	if version, err := source.latestVersion(ChannelPrerelease); err != nil || version != "1.2.3" {
+		t.Logf("Latest prerelease version is %q (%v), expected the stable 1.2.3.", version, err)
+		t.FailNow()
+	}
+	if content, err := readReleaseFile(source, "1.2.3", releaseChecksumsName, maxReleaseManifestSize); err != nil || string(content) != "checksums" {
+		t.Logf("Release file is %q (%v), expected %q.", content, err, "checksums")
+		t.FailNow()
+	}
+	if _, err := source.open("..", releaseIndexName); err == nil {
+		t.Log("Opening a file outside the release directory succeeded.")
+		t.FailNow()
+	}
+}
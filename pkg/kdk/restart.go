@@ -32,11 +32,14 @@ func Restart(cfg KdkEnvConfig) {
 	// Destroy running KDK container
 	Destroy(cfg, true)
 
-	// Save config with snapshot image tag
-	cfg.ConfigFile.AppConfig.ImageTag = strings.Split(snapshotName, ":")[1]
-	cfg.ConfigFile.ContainerConfig.Image = snapshotName
-
 	// Start KDK container with snapshot image
+	useSnapshot(&cfg, snapshotName)
 	cfg.Start()
 	log.Info("KDK container restarted")
 }
+
+// Points the config at a snapshot image, without saving it
+func useSnapshot(cfg *KdkEnvConfig, snapshotName string) {
+	cfg.ConfigFile.AppConfig.ImageTag = strings.Split(snapshotName, ":")[1]
+	cfg.ConfigFile.ContainerConfig.Image = snapshotName
+}
@@ -0,0 +1,55 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRestartStartsSnapshotWithoutBuilding(t *testing.T) {
+	defer useTempHome(t)()
+
+	cfg, fake := fakeKdkEnvConfig(t)
+	cfg.ConfigFile.AppConfig.ImageRepository = "registry.example.com/kdk"
+	cfg.ConfigFile.AppConfig.Extensions = &Extensions{Packages: []string{"jq"}}
+	snapshotName := snapshotRepository + ":" + snapshotTagPrefix(*cfg) + "20200101000000"
+
+	useSnapshot(cfg, snapshotName)
+	if err := prepareImage(cfg); err != nil {
+		t.Logf("Preparing the snapshot image failed: %v", err)
+		t.FailNow()
+	}
+	if len(fake.calls) != 0 {
+		t.Logf("Preparing the snapshot image made calls %v, expected none.", fake.calls)
+		t.FailNow()
+	}
+	if cfg.ConfigFile.ContainerConfig.Image != snapshotName {
+		t.Logf("Container image is %s, expected the snapshot %s.", cfg.ConfigFile.ContainerConfig.Image, snapshotName)
+		t.FailNow()
+	}
+	if _, err := os.Stat(cfg.ConfigPath()); !os.IsNotExist(err) {
+		t.Log("Restart saved the snapshot image to the config.")
+		t.FailNow()
+	}
+
+	// The base image is still pulled and extended on a fresh start
+	cfg.ConfigFile.AppConfig.ImageTag = "1.0.0"
+	cfg.ConfigFile.ContainerConfig.Image = cfg.ContainerImageCoordinates()
+	if err := prepareImage(cfg); err == nil || len(fake.calls) == 0 {
+		t.Logf("Preparing the base image made calls %v, expected a build.", fake.calls)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/ghodss/yaml"
+	log "github.com/sirupsen/logrus"
+)
+
+// What the last `kdk update` replaced
+type rollbackState struct {
+	Version    string     // kdk binary version
+	ConfigFile configFile // KDK config
+}
+
+// kdk binary directory (~/.kdk/bin)
+func (c *KdkEnvConfig) BinDir() (out string) {
+	return filepath.Join(c.ConfigRootDir(), "bin")
+}
+
+// path at which a replaced kdk binary is kept (~/.kdk/bin/kdk-<VERSION>)
+func (c *KdkEnvConfig) ArchivedBinaryPath(version string) (out string) {
+	name := "kdk-" + version
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return filepath.Join(c.BinDir(), name)
+}
+
+// kdk rollback state path (~/.kdk/<KDK_NAME>/rollback.yaml)
+func (c *KdkEnvConfig) RollbackPath() (out string) {
+	return filepath.Join(c.ConfigDir(), "rollback.yaml")
+}
+
+// Records the running binary version and the KDK config before they are updated
+func saveRollbackState(cfg *KdkEnvConfig) error {
+	y, err := yaml.Marshal(rollbackState{Version: Version, ConfigFile: cfg.ConfigFile})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cfg.RollbackPath(), y, 0600)
+}
+
+func loadRollbackState(cfg *KdkEnvConfig) (state rollbackState, err error) {
+	y, err := ioutil.ReadFile(cfg.RollbackPath())
+	if err != nil {
+		return state, err
+	}
+	err = yaml.Unmarshal(y, &state)
+	return state, err
+}
+
+// Restores the binary, image tag and config in place before the last update
+func rollbackUpdate(cfg *KdkEnvConfig) (result UpdateResult) {
+	state, err := loadRollbackState(cfg)
+	if os.IsNotExist(err) {
+		log.Fatal("Nothing to roll back.  No previous `kdk update` was recorded for KDK [" + cfg.ConfigFile.AppConfig.Name + "]")
+	} else if err != nil {
+		log.WithField("error", err).Fatal("Failed to read KDK rollback state")
+	}
+	result.TargetVersion = state.Version
+
+	if needsUpdateBin(state.Version) {
+		archivedBinFile := cfg.ArchivedBinaryPath(state.Version)
+		if _, err := os.Stat(archivedBinFile); err != nil {
+			log.WithField("error", err).Fatal("Previous KDK binary is missing")
+		}
+		binFile, err := cfg.binaryInstallPath()
+		if err != nil {
+			log.WithField("error", err).Fatal("Unable to restore KDK binary")
+		}
+		log.WithField("version", state.Version).Info("Restoring KDK binary")
+		if err := installBinary(cfg, archivedBinFile, binFile); err != nil {
+			log.WithField("error", err).Fatal("Failed to restore KDK bin")
+		}
+		result.BinaryUpdated = true
+	}
+
+	if image := state.ConfigFile.ContainerConfig.Image; image != cfg.ConfigFile.ContainerConfig.Image {
+		if !hasKdkImageWithTag(cfg, state.ConfigFile.AppConfig.ImageTag) {
+			log.WithField("image", image).Info("Pulling previous KDK container image")
+			if err := pullImage(cfg, state.ConfigFile.AppConfig.ImageRepository+":"+state.ConfigFile.AppConfig.ImageTag); err != nil {
+				log.WithField("error", err).Fatal("Failed to restore KDK image")
+			}
+			result.ImageUpdated = true
+		}
+	}
+
+	log.Info("Restoring KDK config")
+	cfg.ConfigFile = state.ConfigFile
+	if err := cfg.WriteConfig(); err != nil {
+		log.WithField("error", err).Fatal("Failed to restore KDK config")
+	}
+	result.ConfigUpdated = true
+
+	if err := os.Remove(cfg.RollbackPath()); err != nil {
+		log.WithField("error", err).Warn("Failed to remove KDK rollback state")
+	}
+	result.RolledBack = true
+	log.Info("Rolled back KDK to version " + state.Version + ".  Run `kdk destroy && kdk up` to recreate the KDK container from the restored image")
+	return result
+}
@@ -0,0 +1,74 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/mitchellh/go-homedir"
+)
+
+func TestRollbackStateRoundTrip(t *testing.T) {
+
+	home, err := ioutil.TempDir("", "kdk-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+	homedir.DisableCache = true
+	defer func() { homedir.DisableCache = false }()
+
+	cfg := KdkEnvConfig{}
+	cfg.ConfigFile.AppConfig.Name = "kdk1"
+	cfg.ConfigFile.AppConfig.ImageTag = "1.0.0"
+	cfg.ConfigFile.ContainerConfig = &container.Config{Image: "ciscosso/kdk:1.0.0", Labels: map[string]string{"kdk": "1.0.0"}}
+	if err := os.MkdirAll(cfg.ConfigDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveRollbackState(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// The update moves the config on; the recorded state keeps what it replaced
+	cfg.ConfigFile.AppConfig.ImageTag = "1.1.0"
+	cfg.ConfigFile.ContainerConfig.Labels["kdk"] = "1.1.0"
+	state, err := loadRollbackState(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Version != Version || state.ConfigFile.AppConfig.ImageTag != "1.0.0" || state.ConfigFile.ContainerConfig.Labels["kdk"] != "1.0.0" {
+		t.Logf("Rollback state is %+v, expected binary version %s and image tag 1.0.0.", state, Version)
+		t.FailNow()
+	}
+}
+
+func TestUpdateChannelDefaultsToStable(t *testing.T) {
+
+	cfg := KdkEnvConfig{}
+	if cfg.UpdateChannel() != ChannelStable {
+		t.Logf("Update channel is %s, expected %s.", cfg.UpdateChannel(), ChannelStable)
+		t.FailNow()
+	}
+	cfg.ConfigFile.AppConfig.Update = &UpdateSettings{Channel: ChannelPrerelease}
+	if cfg.UpdateChannel() != ChannelPrerelease {
+		t.Logf("Update channel is %s, expected %s.", cfg.UpdateChannel(), ChannelPrerelease)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/mitchellh/go-homedir"
+)
+
+// Container engines speaking the docker API
+const (
+	EngineDocker = "docker"
+	EnginePodman = "podman"
+	EngineColima = "colima"
+	EngineCustom = "custom"
+)
+
+// Container runtime configuration.  All fields are optional; the engine and
+// its socket are auto-discovered when unset.
+type Runtime struct {
+	Engine       string `json:",omitempty"` // docker | podman | colima | custom
+	Socket       string `json:",omitempty"` // socket path or engine URL (e.g. unix:///run/podman/podman.sock)
+	Privileged   *bool  `json:",omitempty"` // override the engine default for privileged mode
+	HostGateway  string `json:",omitempty"` // override the name at which the container reaches the host
+	SELinuxLabel string `json:",omitempty"` // relabeling of all bind mounts: z (shared), Z (private) or none
+}
+
+// Engine specific defaults applied at container creation
+type engineProfile struct {
+	hostGateway  string
+	selinuxLabel string
+}
+
+func engineProfileFor(engine string) engineProfile {
+	profile := engineProfile{hostGateway: "host.docker.internal"}
+	switch engine {
+	case EnginePodman:
+		profile.hostGateway = "host.containers.internal"
+		// Podman hosts generally enforce SELinux, which denies access to unlabeled bind mounts
+		if runtime.GOOS == "linux" {
+			profile.selinuxLabel = "z"
+		}
+	case EngineColima:
+		profile.hostGateway = "host.lima.internal"
+	default:
+		if selinuxEnforcing() {
+			profile.selinuxLabel = "z"
+		}
+	}
+	return profile
+}
+
+// Candidate engine sockets in order of preference
+func engineSockets(engine string) []string {
+	home, _ := homedir.Dir()
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+
+	switch engine {
+	case EngineDocker:
+		return []string{
+			"/var/run/docker.sock",
+			filepath.Join(home, ".docker", "run", "docker.sock"),
+			filepath.Join(home, ".docker", "desktop", "docker.sock"),
+			filepath.Join(runtimeDir, "docker.sock"),
+		}
+	case EnginePodman:
+		if runtime.GOOS == "windows" {
+			return []string{"npipe:////./pipe/podman-machine-default"}
+		}
+		machineDir := filepath.Join(home, ".local", "share", "containers", "podman", "machine")
+		return []string{
+			filepath.Join(runtimeDir, "podman", "podman.sock"),
+			"/run/podman/podman.sock",
+			filepath.Join(machineDir, "podman.sock"),
+			filepath.Join(machineDir, "qemu", "podman.sock"),
+			filepath.Join(machineDir, "podman-machine-default", "podman.sock"),
+		}
+	case EngineColima:
+		colimaHome := os.Getenv("COLIMA_HOME")
+		if colimaHome == "" {
+			colimaHome = filepath.Join(home, ".colima")
+		}
+		return []string{
+			filepath.Join(colimaHome, "default", "docker.sock"),
+			filepath.Join(colimaHome, "docker.sock"),
+		}
+	}
+	return nil
+}
+
+// Returns the first existing socket of the engine as an engine URL
+func discoverEngineSocket(engine string) string {
+	for _, socket := range engineSockets(engine) {
+		if strings.HasPrefix(socket, "npipe://") {
+			return socket
+		}
+		if socket == "" || !filepath.IsAbs(socket) {
+			continue
+		}
+		if _, err := os.Stat(socket); err == nil {
+			return "unix://" + socket
+		}
+	}
+	return ""
+}
+
+// Resolves the engine and the engine URL to connect to.  An empty URL means
+// that the docker client defaults (including DOCKER_HOST) apply.
+func resolveEngine(rt Runtime) (engine string, host string, err error) {
+	if rt.Socket != "" {
+		host = rt.Socket
+		if filepath.IsAbs(host) && !strings.Contains(host, "://") {
+			host = "unix://" + host
+		}
+	}
+	engine = rt.Engine
+
+	switch engine {
+	case EngineCustom:
+		if host == "" {
+			return "", "", fmt.Errorf("runtime engine [%s] requires Runtime.Socket", engine)
+		}
+	case EngineDocker, EnginePodman, EngineColima:
+		if host == "" && os.Getenv("DOCKER_HOST") == "" {
+			if host = discoverEngineSocket(engine); host == "" {
+				return "", "", fmt.Errorf("unable to find a %s socket; set Runtime.Socket", engine)
+			}
+		}
+	case "":
+		// Auto-detect from DOCKER_HOST, otherwise from the first engine socket found
+		if dockerHost := os.Getenv("DOCKER_HOST"); host == "" && dockerHost != "" {
+			engine = EngineDocker
+			for _, candidate := range []string{EnginePodman, EngineColima} {
+				if strings.Contains(dockerHost, candidate) {
+					engine = candidate
+				}
+			}
+			return engine, "", nil
+		}
+		engine = EngineDocker
+		if host == "" {
+			for _, candidate := range []string{EngineDocker, EnginePodman, EngineColima} {
+				if socket := discoverEngineSocket(candidate); socket != "" {
+					return candidate, socket, nil
+				}
+			}
+		}
+	default:
+		return "", "", fmt.Errorf("unknown runtime engine [%s]", engine)
+	}
+	return engine, host, nil
+}
+
+// Runtime config, never nil
+func (c *KdkEnvConfig) runtimeConfig() Runtime {
+	if c.ConfigFile.AppConfig.Runtime == nil {
+		return Runtime{}
+	}
+	return *c.ConfigFile.AppConfig.Runtime
+}
+
+// Container engine the KDK runs on (docker, podman, colima or custom)
+func (c *KdkEnvConfig) Engine() string {
+	if c.engine == "" {
+		return EngineDocker
+	}
+	return c.engine
+}
+
+// Name at which the KDK container reaches the docker host
+func (c *KdkEnvConfig) HostGateway() string {
+	if gateway := c.runtimeConfig().HostGateway; gateway != "" {
+		return gateway
+	}
+	return engineProfileFor(c.Engine()).hostGateway
+}
+
+// Returns copies of the configured container and host configs with runtime
+// specific adjustments applied.  These are derived at creation time rather
+// than persisted so that configs remain portable between engines.
+func containerCreateConfig(cfg KdkEnvConfig) (*container.Config, *container.HostConfig) {
+	containerConfig := *cfg.ConfigFile.ContainerConfig
+	hostConfig := *cfg.ConfigFile.HostConfig
+	containerConfig.Env = append([]string{}, containerConfig.Env...)
+	hostConfig.Mounts = append([]mount.Mount{}, hostConfig.Mounts...)
+	hostConfig.Binds = append([]string{}, hostConfig.Binds...)
+	containerConfig.Labels = map[string]string{}
+	for key, value := range cfg.ConfigFile.ContainerConfig.Labels {
+		containerConfig.Labels[key] = value
+	}
+	if source := cfg.secretsMountSource(); source != "" {
+		containerConfig.Labels[secretsLabel] = source
+	}
+	containerConfig.Volumes = map[string]struct{}{}
+	for target := range cfg.ConfigFile.ContainerConfig.Volumes {
+		containerConfig.Volumes[target] = struct{}{}
+	}
+
+	rt := cfg.runtimeConfig()
+	profile := engineProfileFor(cfg.Engine())
+
+	// Rootless engines may refuse privileged containers
+	if rt.Privileged != nil {
+		hostConfig.Privileged = *rt.Privileged
+	}
+
+	containerConfig.Env = setEnv(containerConfig.Env, "KDK_HOST_GATEWAY", cfg.HostGateway())
+	containerConfig.Env = mergeEnv(containerConfig.Env, hostUserIDEnv(cfg))
+	if proxy := cfg.containerProxy(); proxy != nil {
+		containerConfig.Env = mergeEnv(containerConfig.Env, proxy.env())
+	}
+	applyNetworkConfig(cfg, &hostConfig)
+
+	// The public key is copied into containers on remote engines rather than bind mounted
+	if cfg.IsRemoteEngine() {
+		var mounts []mount.Mount
+		for _, m := range hostConfig.Mounts {
+			if m.Target != publicKeyContainerPath {
+				mounts = append(mounts, m)
+			}
+		}
+		hostConfig.Mounts = mounts
+		delete(containerConfig.Volumes, publicKeyContainerPath)
+	}
+
+	// The mount API has no relabel option, so relabeled bind mounts are passed as
+	// binds.  Engine defaults only relabel the KDK public key; relabeling user
+	// directories, which other host processes may rely on, is opt-in.
+	label, relabelAll := profile.selinuxLabel, false
+	if rt.SELinuxLabel != "" {
+		label, relabelAll = rt.SELinuxLabel, true
+	}
+	if label != "" && label != "none" {
+		var mounts []mount.Mount
+		for _, m := range hostConfig.Mounts {
+			if m.Type != mount.TypeBind || (!relabelAll && m.Target != publicKeyContainerPath) {
+				mounts = append(mounts, m)
+				continue
+			}
+			options := []string{label}
+			if m.ReadOnly {
+				options = append(options, "ro")
+			}
+			hostConfig.Binds = append(hostConfig.Binds, m.Source+":"+m.Target+":"+strings.Join(options, ","))
+		}
+		hostConfig.Mounts = mounts
+	}
+	return &containerConfig, &hostConfig
+}
+
+// Sets an environment variable in a KEY=value list unless the key is already present
+func setEnv(env []string, key, value string) []string {
+	for _, item := range env {
+		if strings.HasPrefix(item, key+"=") {
+			return env
+		}
+	}
+	return append(env, key+"="+value)
+}
+
+// Sets each KEY=value variable in an environment list unless the key is already present
+func mergeEnv(env []string, variables []string) []string {
+	for _, variable := range variables {
+		keyValue := strings.SplitN(variable, "=", 2)
+		if len(keyValue) == 2 {
+			env = setEnv(env, keyValue[0], keyValue[1])
+		}
+	}
+	return env
+}
+
+// check if the local host enforces SELinux
+func selinuxEnforcing() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	enforce, err := ioutil.ReadFile("/sys/fs/selinux/enforce")
+	return err == nil && strings.TrimSpace(string(enforce)) == "1"
+}
@@ -0,0 +1,91 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+)
+
+func TestContainerCreateConfigRelabelsBindMounts(t *testing.T) {
+
+	privileged := false
+	cfg := KdkEnvConfig{engine: EnginePodman}
+	cfg.ConfigFile.AppConfig.Runtime = &Runtime{SELinuxLabel: "Z", Privileged: &privileged}
+	cfg.ConfigFile.ContainerConfig = &container.Config{}
+	cfg.ConfigFile.HostConfig = &container.HostConfig{
+		Privileged: true,
+		Mounts: []mount.Mount{
+			{Type: mount.TypeBind, Source: "/home/user/.kdk/ssh/id_rsa.pub", Target: "/tmp/id_rsa.pub", ReadOnly: true},
+			{Type: mount.TypeVolume, Source: "kdk-home", Target: "/home/user"},
+			{Type: mount.TypeBind, Source: "/home/user/src", Target: "/home/user/src"},
+		},
+	}
+
+	containerConfig, hostConfig := containerCreateConfig(cfg)
+
+	expectedBinds := []string{"/home/user/.kdk/ssh/id_rsa.pub:/tmp/id_rsa.pub:Z,ro", "/home/user/src:/home/user/src:Z"}
+	if !reflect.DeepEqual(hostConfig.Binds, expectedBinds) {
+		t.Logf("Binds are %v, expected %v.", hostConfig.Binds, expectedBinds)
+		t.FailNow()
+	}
+	if len(hostConfig.Mounts) != 1 || hostConfig.Mounts[0].Type != mount.TypeVolume {
+		t.Logf("Mounts are %v, expected only the volume mount.", hostConfig.Mounts)
+		t.FailNow()
+	}
+	if hostConfig.Privileged {
+		t.Log("Runtime.Privileged override was not applied.")
+		t.FailNow()
+	}
+	if len(containerConfig.Env) == 0 || containerConfig.Env[0] != "KDK_HOST_GATEWAY=host.containers.internal" {
+		t.Logf("Env is %v, expected the podman host gateway.", containerConfig.Env)
+		t.FailNow()
+	}
+	if len(cfg.ConfigFile.HostConfig.Mounts) != 3 || !cfg.ConfigFile.HostConfig.Privileged {
+		t.Log("containerCreateConfig modified the persisted config.")
+		t.FailNow()
+	}
+}
+
+func TestContainerCreateConfigRelabelsOnlyKeyByDefault(t *testing.T) {
+
+	cfg := KdkEnvConfig{engine: EnginePodman}
+	cfg.ConfigFile.ContainerConfig = &container.Config{}
+	cfg.ConfigFile.HostConfig = &container.HostConfig{
+		Mounts: []mount.Mount{
+			{Type: mount.TypeBind, Source: "/home/user/.kdk/ssh/id_rsa.pub", Target: publicKeyContainerPath, ReadOnly: true},
+			{Type: mount.TypeBind, Source: "/home/user/src", Target: "/home/user/src"},
+		},
+	}
+
+	_, hostConfig := containerCreateConfig(cfg)
+
+	var expectedBinds []string
+	if runtime.GOOS == "linux" {
+		expectedBinds = []string{"/home/user/.kdk/ssh/id_rsa.pub:" + publicKeyContainerPath + ":z,ro"}
+	}
+	if !reflect.DeepEqual(hostConfig.Binds, expectedBinds) {
+		t.Logf("Binds are %v, expected %v.", hostConfig.Binds, expectedBinds)
+		t.FailNow()
+	}
+	if mounts := hostConfig.Mounts; len(mounts) == 0 || mounts[len(mounts)-1].Source != "/home/user/src" {
+		t.Logf("Mounts are %v, expected the user directory mounted without relabeling.", mounts)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/cisco-sso/kdk/pkg/secrets"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	log "github.com/sirupsen/logrus"
+)
+
+// Label on KDK containers holding the host path of their secrets mount
+const secretsLabel = "kdk.secrets"
+
+// Configs created before secrets providers mount keybase without recording
+// it.  Records the keybase provider for them so that it is started with the
+// KDK, and returns whether the config was changed.
+func migrateSecrets(cfg *configFile) bool {
+	if cfg.AppConfig.Secrets != nil || cfg.HostConfig == nil {
+		return false
+	}
+	for _, m := range cfg.HostConfig.Mounts {
+		if m.Target == "/keybase" {
+			cfg.AppConfig.Secrets = &secrets.Config{Provider: secrets.ProviderKeybase}
+			// On windows the mount is of the keybase mirror, which is not the keybase root
+			if runtime.GOOS != "windows" {
+				cfg.AppConfig.Secrets.Source = m.Source
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Host path of the secrets mount of the KDK, if any
+func (c *KdkEnvConfig) secretsMountSource() string {
+	source, err := secrets.MountSource(c.ConfigRootDir(), c.ConfigFile.AppConfig.Secrets)
+	if err != nil {
+		log.WithField("error", err).Debug("Failed to resolve secrets mount")
+		return ""
+	}
+	return source
+}
+
+// Names of the running containers labelled as mounting the secrets filesystem at source
+func secretsContainers(cfg KdkEnvConfig, source string) ([]string, error) {
+	containers, err := cfg.DockerClient.ContainerList(cfg.Ctx, types.ContainerListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", secretsLabel+"="+source)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, container := range containers {
+		if len(container.Names) > 0 {
+			names = append(names, strings.TrimPrefix(container.Names[0], "/"))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Releases the secrets filesystem of the KDK unless a running container still mounts it
+func ReleaseSecrets(cfg KdkEnvConfig) error {
+	source := cfg.secretsMountSource()
+	if source == "" {
+		return nil
+	}
+	containers, err := secretsContainers(cfg, source)
+	if err != nil {
+		return err
+	}
+	return secrets.Stop(cfg.ConfigRootDir(), cfg.ConfigFile.AppConfig.Secrets, containers)
+}
+
+// Returns the state of the secrets filesystem of the KDK, or nil if it has none
+func secretsStatus(cfg KdkEnvConfig) (*SecretsStatus, error) {
+	source := cfg.secretsMountSource()
+	if source == "" {
+		return nil, nil
+	}
+	secretsConfig := cfg.ConfigFile.AppConfig.Secrets
+	containers, err := secretsContainers(cfg, source)
+	if err != nil {
+		return nil, err
+	}
+	active, err := secrets.Active(cfg.ConfigRootDir(), secretsConfig)
+	if err != nil {
+		log.WithField("error", err).Debug("Failed to check secrets filesystem")
+	}
+	return &SecretsStatus{
+		Provider:   secretsConfig.Provider,
+		Source:     source,
+		Target:     secretsConfig.MountTarget(),
+		Active:     active,
+		Containers: containers,
+	}, nil
+}
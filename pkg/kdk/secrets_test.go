@@ -0,0 +1,63 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cisco-sso/kdk/pkg/secrets"
+	"github.com/docker/docker/api/types/mount"
+)
+
+func TestSecretsStatusCountsLabelledRunningContainers(t *testing.T) {
+
+	defer useTempHome(t)()
+
+	source := "/home/user/.password-store"
+	cfg, fake := fakeKdkEnvConfig(t,
+		&fakeContainer{id: "a", name: "kdk", state: "running", labels: map[string]string{secretsLabel: source}},
+		&fakeContainer{id: "b", name: "kdk-stopped", state: "exited", labels: map[string]string{secretsLabel: source}},
+		&fakeContainer{id: "c", name: "kdk-other", state: "running", labels: map[string]string{secretsLabel: "/elsewhere"}},
+		&fakeContainer{id: "d", name: "kdk-team", state: "running", labels: map[string]string{secretsLabel: source}},
+	)
+	cfg.ConfigFile.AppConfig.Secrets = &secrets.Config{Provider: secrets.ProviderPass, Source: source}
+	cfg.ConfigFile.HostConfig.Mounts = []mount.Mount{{Type: mount.TypeBind, Source: source, Target: secrets.DefaultTarget}}
+
+	status, err := secretsStatus(*cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == nil || status.Source != source || status.Target != secrets.DefaultTarget ||
+		!reflect.DeepEqual(status.Containers, []string{"kdk", "kdk-team"}) {
+		t.Logf("Secrets status is %+v, expected the running containers labelled with %s.", status, source)
+		t.FailNow()
+	}
+	containerConfig, _ := containerCreateConfig(*cfg)
+	if containerConfig.Labels[secretsLabel] != source || cfg.ConfigFile.ContainerConfig.Labels[secretsLabel] != "" {
+		t.Logf("Created container labels are %v, expected %s=%s without changing the config.", containerConfig.Labels, secretsLabel, source)
+		t.FailNow()
+	}
+
+	// Destroying the last user releases the filesystem
+	fake.containers = fake.containers[:1]
+	if err := Destroy(*cfg, true); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := secretsStatus(*cfg); len(status.Containers) != 0 || len(fake.containers) != 0 {
+		t.Logf("Secrets status is %+v after destroy, expected no users.", status)
+		t.FailNow()
+	}
+}
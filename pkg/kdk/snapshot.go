@@ -15,19 +15,67 @@
 package kdk
 
 import (
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
 	log "github.com/sirupsen/logrus"
 )
 
+// Repository of KDK snapshot images, which are tagged <user>-<name>-<timestamp>
+const snapshotRepository = "ciscosso/kdk"
+
+var snapshotTimestamp = regexp.MustCompile(`^[0-9]{14}$`)
+
+func snapshotTagPrefix(cfg KdkEnvConfig) string {
+	return cfg.User() + "-" + cfg.ConfigFile.AppConfig.Name + "-"
+}
+
+// Reports whether image is a snapshot of this KDK
+func isSnapshotImage(cfg KdkEnvConfig, image string) bool {
+	prefix := snapshotRepository + ":" + snapshotTagPrefix(cfg)
+	return strings.HasPrefix(image, prefix) && snapshotTimestamp.MatchString(strings.TrimPrefix(image, prefix))
+}
+
 func Snapshot(cfg KdkEnvConfig) (string, error) {
-	snapshotName := "ciscosso/kdk" + ":" + cfg.User() + "-" + cfg.ConfigFile.AppConfig.Name + "-" + time.Now().Format("20060102150405")
+	snapshotName := snapshotRepository + ":" + snapshotTagPrefix(cfg) + time.Now().Format("20060102150405")
 	_, err := cfg.DockerClient.ContainerCommit(cfg.Ctx, cfg.ConfigFile.AppConfig.Name, types.ContainerCommitOptions{Reference: snapshotName})
 	if err != nil {
-		log.WithField("error", err).Fatal("Failed to create snapshot of KDK container")
+		log.WithField("error", err).Error("Failed to create snapshot of KDK container")
 		return "", err
 	}
 	log.Info("Successfully created snapshot of KDK container.", snapshotName)
 	return snapshotName, nil
 }
+
+// Returns the snapshot images of the KDK, oldest first
+func SnapshotList(cfg KdkEnvConfig) (SnapshotListResult, error) {
+	images, err := cfg.DockerClient.ImageList(cfg.Ctx, types.ImageListOptions{
+		Filters: filters.NewArgs(filters.Arg("reference", snapshotRepository)),
+	})
+	if err != nil {
+		return SnapshotListResult{}, err
+	}
+	prefix := snapshotRepository + ":" + snapshotTagPrefix(cfg)
+	result := SnapshotListResult{Snapshots: []SnapshotResult{}}
+	for _, image := range images {
+		for _, tag := range image.RepoTags {
+			// The timestamp check excludes KDKs whose names extend this one (kdk, kdk-2)
+			if strings.HasPrefix(tag, prefix) && snapshotTimestamp.MatchString(strings.TrimPrefix(tag, prefix)) {
+				result.Snapshots = append(result.Snapshots, SnapshotResult{
+					Image:   tag,
+					ID:      image.ID,
+					Created: time.Unix(image.Created, 0).UTC(),
+					Size:    image.Size,
+				})
+			}
+		}
+	}
+	sort.Slice(result.Snapshots, func(i, j int) bool {
+		return result.Snapshots[i].Image < result.Snapshots[j].Image
+	})
+	return result, nil
+}
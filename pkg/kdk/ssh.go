@@ -30,6 +30,11 @@ func Ssh(cfg KdkEnvConfig) {
 	// If KDK container is not running, start it and provision KDK user.
 	cfg.Start()
 
+	// Wait for sshd and provisioning so that the first connection doesn't race startup
+	if err := cfg.WaitReady(); err != nil {
+		log.WithField("error", err).Fatal("KDK container did not become ready.")
+	}
+
 	// Build socksString
 	var socksString string
 	if cfg.ConfigFile.AppConfig.SocksPort != "" {
@@ -0,0 +1,136 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Header of the generated OpenSSH config, which is overwritten on refresh
+const sshConfigHeader = "# Generated by `kdk ssh-config --install`; changes are overwritten.\n"
+
+// OpenSSH client config path (~/.ssh/config)
+func (c *KdkEnvConfig) UserSSHConfigPath() (out string) {
+	return filepath.Join(c.Home(), ".ssh", "config")
+}
+
+// Generated OpenSSH config path of all KDKs, included from ~/.ssh/config (~/.ssh/kdk.config)
+func (c *KdkEnvConfig) SSHConfigPath() (out string) {
+	return filepath.Join(c.Home(), ".ssh", "kdk.config")
+}
+
+// Returns the OpenSSH config of every KDK instance, with a `Host kdk-<name>` entry each
+func (c *KdkEnvConfig) SSHConfig() (string, error) {
+	instanceConfigs, err := loadInstanceConfigs(c.ConfigRootDir())
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for name := range instanceConfigs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out bytes.Buffer
+	for _, name := range names {
+		instance := KdkEnvConfig{ConfigFile: instanceConfigs[name]}
+		instance.ConfigFile.AppConfig.Name = name
+		if instance.ConfigFile.AppConfig.Port == "" {
+			continue
+		}
+		writeSSHConfigEntry(&out, &instance)
+	}
+	return out.String(), nil
+}
+
+func writeSSHConfigEntry(out io.Writer, c *KdkEnvConfig) {
+	fmt.Fprintf(out, "Host %s\n", c.HostKeyAlias())
+	option := func(key, value string) {
+		if strings.ContainsAny(value, " \t") {
+			value = `"` + value + `"`
+		}
+		fmt.Fprintf(out, "  %s %s\n", key, value)
+	}
+	option("HostName", c.SSHHost())
+	option("Port", c.ConfigFile.AppConfig.Port)
+	option("User", c.User())
+	option("IdentityFile", c.PrivateKeyPath())
+	option("IdentitiesOnly", "yes")
+	option("ForwardAgent", "yes")
+	if c.ConfigFile.AppConfig.SocksPort != "" {
+		option("DynamicForward", c.ConfigFile.AppConfig.SocksPort)
+	}
+	if jumpHost := c.SSHJumpHost(); jumpHost != "" {
+		option("ProxyJump", jumpHost)
+	}
+	c.ensureKnownHosts()
+	option("HostKeyAlias", c.HostKeyAlias())
+	option("StrictHostKeyChecking", "yes")
+	option("UserKnownHostsFile", c.KnownHostsPath())
+	fmt.Fprintln(out)
+}
+
+// Writes the OpenSSH config of every KDK to ~/.ssh/kdk.config and includes it
+// from ~/.ssh/config
+func (c *KdkEnvConfig) InstallSSHConfig() error {
+	sshDir := filepath.Dir(c.SSHConfigPath())
+	if err := os.MkdirAll(sshDir, 0700); err != nil {
+		return err
+	}
+	if err := c.writeSSHConfig(); err != nil {
+		return err
+	}
+
+	// Include is scoped to the preceding Host entry, so it goes at the top of the file
+	include := "Include " + c.SSHConfigPath()
+	userConfig, err := ioutil.ReadFile(c.UserSSHConfigPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(userConfig), "\n") {
+		if strings.TrimSpace(line) == include {
+			return nil
+		}
+	}
+	log.Infof("Adding `%s` to %s", include, c.UserSSHConfigPath())
+	return ioutil.WriteFile(c.UserSSHConfigPath(), append([]byte(include+"\n\n"), userConfig...), 0600)
+}
+
+func (c *KdkEnvConfig) writeSSHConfig() error {
+	sshConfig, err := c.SSHConfig()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.SSHConfigPath(), []byte(sshConfigHeader+"\n"+sshConfig), 0600)
+}
+
+// Regenerates ~/.ssh/kdk.config if it was installed, so that it follows port and key changes
+func (c *KdkEnvConfig) refreshSSHConfig() {
+	if _, err := os.Stat(c.SSHConfigPath()); err != nil {
+		return
+	}
+	if err := c.writeSSHConfig(); err != nil {
+		log.WithField("error", err).Warnf("Failed to refresh %s", c.SSHConfigPath())
+	}
+}
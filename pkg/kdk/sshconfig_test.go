@@ -0,0 +1,79 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+func TestInstallSSHConfig(t *testing.T) {
+
+	home, err := ioutil.TempDir("", "kdk-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+	homedir.DisableCache = true
+	defer func() { homedir.DisableCache = false }()
+
+	cfg := KdkEnvConfig{}
+	cfg.ConfigFile.AppConfig.Name = "kdk1"
+	cfg.ConfigFile.AppConfig.Port = "40000"
+	cfg.ConfigFile.AppConfig.SocksPort = "8000"
+	if err := os.MkdirAll(cfg.ConfigDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(home, ".ssh"), 0700)
+	ioutil.WriteFile(cfg.UserSSHConfigPath(), []byte("Host example\n  User me\n"), 0600)
+
+	if err := cfg.InstallSSHConfig(); err != nil {
+		t.Fatal(err)
+	}
+	// Installing again must not include the config twice
+	if err := cfg.InstallSSHConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	userConfig, _ := ioutil.ReadFile(cfg.UserSSHConfigPath())
+	expectedUserConfig := "Include " + cfg.SSHConfigPath() + "\n\nHost example\n  User me\n"
+	if string(userConfig) != expectedUserConfig {
+		t.Logf("~/.ssh/config is %q, expected %q.", userConfig, expectedUserConfig)
+		t.FailNow()
+	}
+
+	// Port changes are picked up when the config is written
+	cfg.ConfigFile.AppConfig.Port = "40001"
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	sshConfig, _ := ioutil.ReadFile(cfg.SSHConfigPath())
+	for _, expected := range []string{"Host kdk-kdk1\n", "  Port 40001\n", "  DynamicForward 8000\n"} {
+		if !strings.Contains(string(sshConfig), expected) {
+			t.Logf("~/.ssh/kdk.config lacks %q:\n%s", expected, sshConfig)
+			t.FailNow()
+		}
+	}
+}
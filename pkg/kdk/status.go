@@ -0,0 +1,75 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"sort"
+)
+
+// Returns the status of the KDK
+func Status(cfg KdkEnvConfig) (InstanceStatus, error) {
+	containerID, state, err := containerState(cfg)
+	if err != nil {
+		return InstanceStatus{}, err
+	}
+	if state == "" {
+		state = "absent"
+	}
+	image := cfg.ImageCoordinates()
+	if cfg.ConfigFile.ContainerConfig != nil {
+		image = cfg.ConfigFile.ContainerConfig.Image
+	}
+	secrets, err := secretsStatus(cfg)
+	if err != nil {
+		return InstanceStatus{}, err
+	}
+	return InstanceStatus{
+		Name:        cfg.ConfigFile.AppConfig.Name,
+		State:       state,
+		ContainerID: containerID,
+		Image:       image,
+		SSHAddress:  cfg.SSHAddress(),
+		SSHPort:     cfg.ConfigFile.AppConfig.Port,
+		SocksPort:   cfg.ConfigFile.AppConfig.SocksPort,
+		BindAddress: cfg.BindAddress(),
+		Secrets:     secrets,
+	}, nil
+}
+
+// Returns the status of every KDK instance, as seen by the current docker engine
+func List(cfg KdkEnvConfig) (ListResult, error) {
+	instanceConfigs, err := loadInstanceConfigs(cfg.ConfigRootDir())
+	if err != nil {
+		return ListResult{}, err
+	}
+	var names []string
+	for name := range instanceConfigs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := ListResult{Instances: []InstanceStatus{}}
+	for _, name := range names {
+		instance := cfg
+		instance.ConfigFile = instanceConfigs[name]
+		instance.ConfigFile.AppConfig.Name = name
+		status, err := Status(instance)
+		if err != nil {
+			return ListResult{}, err
+		}
+		result.Instances = append(result.Instances, status)
+	}
+	return result, nil
+}
@@ -15,80 +15,269 @@
 package kdk
 
 import (
-	"runtime"
+	"errors"
+	"fmt"
+	"time"
 
-	"github.com/cisco-sso/kdk/pkg/keybase"
 	"github.com/cisco-sso/kdk/pkg/prompt"
+	"github.com/cisco-sso/kdk/pkg/secrets"
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 	log "github.com/sirupsen/logrus"
 )
 
-func Up(cfg KdkEnvConfig) (err error) {
+// Action taken by Up to bring an existing KDK container in a given docker state to running
+type upAction int
 
-	if runtime.GOOS == "windows" {
-		if err := keybase.StartMirror(cfg.ConfigRootDir()); err != nil {
-			log.WithField("error", err).Fatal("Failed to start keybase mirror")
+const (
+	upActionCreate          upAction = iota // no container exists: create and start one
+	upActionNone                            // running: nothing to do
+	upActionStart                           // created: start it
+	upActionUnpause                         // paused: unpause it
+	upActionWait                            // restarting or removing: wait for docker to settle
+	upActionRemove                          // dead: remove it, then create a new one
+	upActionRestartOrRemove                 // exited: ask whether to restart or remove it
+)
+
+// How long Up waits on transient (restarting, removing) container states
+const upTransientStateTimeout = 60 * time.Second
+
+// Maps a docker container state to the action Up takes.  An empty state
+// means that no container exists.
+func upActionForState(state string) (upAction, error) {
+	switch state {
+	case "":
+		return upActionCreate, nil
+	case "running":
+		return upActionNone, nil
+	case "created":
+		return upActionStart, nil
+	case "paused":
+		return upActionUnpause, nil
+	case "restarting", "removing":
+		return upActionWait, nil
+	case "dead":
+		return upActionRemove, nil
+	case "exited":
+		return upActionRestartOrRemove, nil
+	}
+	return upActionNone, fmt.Errorf("unhandled KDK container state [%s]", state)
+}
+
+func Up(cfg *KdkEnvConfig) (err error) {
+
+	if err := secrets.Start(cfg.ConfigRootDir(), cfg.ConfigFile.AppConfig.Secrets); err != nil {
+		log.WithField("error", err).Fatal("Failed to start secrets filesystem")
+		return err
+	}
+
+	name := cfg.ConfigFile.AppConfig.Name
+	waitDeadline := time.Now().Add(upTransientStateTimeout)
+	for {
+		containerID, state, err := containerState(*cfg)
+		if err != nil {
+			log.WithField("error", err).Fatal("Failed to inspect KDK container")
+			return err
+		}
+		action, err := upActionForState(state)
+		if err != nil {
+			log.WithField("error", err).Fatalf("Unable to start KDK container.  Remove it with `docker rm -f %s` and retry", name)
 			return err
 		}
-	}
 
-	containers, err := cfg.DockerClient.ContainerList(cfg.Ctx, types.ContainerListOptions{All: true})
-	if err != nil {
-		log.WithField("error", err).Fatal("Failed to list docker containers")
-	}
-	for _, container := range containers {
-		for _, name := range container.Names {
-			if name == "/"+cfg.ConfigFile.AppConfig.Name {
-				if container.State == "exited" {
-					log.Infof("An exited KDK container exists")
-					p := prompt.Prompt{
-						Text:     "Restart exited KDK container? [y/n] ",
-						Loop:     true,
-						Validate: prompt.ValidateYorN,
-					}
-					if result, err := p.Run(); err == nil && result == "y" {
-						log.Info("Restarting exited KDK container")
-						containerStart(cfg, container.ID)
-						return nil
-					} else {
-						p := prompt.Prompt{
-							Text:     "Delete exited KDK container? [y/n] ",
-							Loop:     true,
-							Validate: prompt.ValidateYorN,
-						}
-						if result, err := p.Run(); err != nil || result == "n" {
-							log.Fatal("KDK exited image deletion canceled or invalid input.")
-						}
-						log.Info("Removing exited KDK container")
-						if err := cfg.DockerClient.ContainerRemove(cfg.Ctx, container.ID, types.ContainerRemoveOptions{Force: true}); err != nil {
-							log.WithField("error", err).Fatalf("Failed to remove exited KDK container [%s]", container.ImageID)
-						}
-					}
+		switch action {
+		case upActionNone:
+			log.Info("KDK container is already running")
+			return nil
+
+		case upActionStart:
+			if recreate, err := reservePortsForStart(cfg, containerID); err != nil {
+				log.WithField("error", err).Fatal("Unable to start KDK container")
+				return err
+			} else if recreate {
+				continue
+			}
+			log.Info("Starting created KDK container")
+			if err := containerStart(*cfg, containerID); err != nil {
+				log.WithField("error", err).Fatal("Failed to start KDK container")
+				return err
+			}
+			return nil
+
+		case upActionUnpause:
+			log.Info("Unpausing paused KDK container")
+			if err := cfg.DockerClient.ContainerUnpause(cfg.Ctx, containerID); err != nil {
+				log.WithField("error", err).Fatal("Failed to unpause KDK container")
+				return err
+			}
+			log.Info("Successfully unpaused KDK container")
+			return nil
+
+		case upActionWait:
+			if time.Now().After(waitDeadline) {
+				log.Fatalf("KDK container still %s after %s.  Check `docker ps -a` or remove it with `docker rm -f %s`",
+					state, upTransientStateTimeout, name)
+				return fmt.Errorf("KDK container stuck in state [%s]", state)
+			}
+			log.Infof("KDK container is %s.  Waiting...", state)
+			time.Sleep(time.Second)
+
+		case upActionRemove:
+			log.Info("Removing dead KDK container")
+			if err := cfg.DockerClient.ContainerRemove(cfg.Ctx, containerID, types.ContainerRemoveOptions{Force: true}); err != nil {
+				log.WithField("error", err).Fatalf("Failed to remove dead KDK container.  Remove it with `docker rm -f %s` and retry", name)
+				return err
+			}
+
+		case upActionRestartOrRemove:
+			log.Infof("An exited KDK container exists")
+			p := prompt.Prompt{
+				Text:     "Restart exited KDK container? [y/n] ",
+				Loop:     true,
+				Validate: prompt.ValidateYorN,
+			}
+			if result, err := p.Run(); err == nil && result == "y" {
+				if recreate, err := reservePortsForStart(cfg, containerID); err != nil {
+					log.WithField("error", err).Fatal("Unable to restart KDK container")
+					return err
+				} else if recreate {
+					continue
+				}
+				log.Info("Restarting exited KDK container")
+				if err := containerStart(*cfg, containerID); err != nil {
+					log.WithField("error", err).Fatal("Failed to start KDK container")
+					return err
+				}
+				return nil
+			}
+			p = prompt.Prompt{
+				Text:     "Delete exited KDK container? [y/n] ",
+				Loop:     true,
+				Validate: prompt.ValidateYorN,
+			}
+			if result, err := p.Run(); err != nil || result == "n" {
+				log.Fatal("KDK exited image deletion canceled or invalid input.")
+			}
+			log.Info("Removing exited KDK container")
+			if err := cfg.DockerClient.ContainerRemove(cfg.Ctx, containerID, types.ContainerRemoveOptions{Force: true}); err != nil {
+				log.WithField("error", err).Fatalf("Failed to remove exited KDK container [%s]", containerID)
+			}
+
+		case upActionCreate:
+			if _, err := createAndStartContainer(cfg); err != nil {
+				if errdefs.IsConflict(err) {
+					log.WithField("error", err).Fatalf("A container named [%s] already exists.  Remove it with `docker rm -f %s` or use a different --name", name, name)
 				}
+				log.WithField("error", err).Fatal("Failed to create KDK container")
+				return err
 			}
+			return nil
 		}
 	}
-	containerID, err := containerCreate(cfg)
+}
+
+// Creates and starts the KDK container, returning its ID
+func createAndStartContainer(cfg *KdkEnvConfig) (string, error) {
+	// Port bindings are fixed at creation, so conflicting ports are reassigned before creating the container
+	if changed, err := reservePorts(cfg, true); err != nil {
+		return "", fmt.Errorf("failed to reserve KDK ports: %v", err)
+	} else if changed {
+		if err := cfg.WriteConfig(); err != nil {
+			return "", fmt.Errorf("failed to write KDK config: %v", err)
+		}
+	}
+	if err := validateRemoteMounts(*cfg); err != nil {
+		return "", fmt.Errorf("invalid KDK config for remote docker host: %v", err)
+	}
+	if err := cfg.CreateKdkHostKey(); err != nil {
+		return "", fmt.Errorf("failed to create KDK ssh host key: %v", err)
+	}
+	if err := ensureNetwork(*cfg); err != nil {
+		return "", fmt.Errorf("failed to create KDK network: %v", err)
+	}
+	containerID, err := containerCreate(*cfg)
+	if err != nil {
+		return "", err
+	}
+	if err := containerStart(*cfg, containerID); err != nil {
+		return containerID, fmt.Errorf("failed to start KDK container: %v", err)
+	}
+	return containerID, nil
+}
+
+// Docker binds published ports when a container starts, so the ports of an
+// existing container are checked against other KDKs and host listeners before
+// each start.  The port bindings of an existing container cannot change, so
+// reassigned ports require recreating it.  Returns whether the container was
+// removed to be recreated.
+func reservePortsForStart(cfg *KdkEnvConfig, containerID string) (recreate bool, err error) {
+	changed, err := reservePorts(cfg, true)
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve KDK ports: %v", err)
+	}
+	if !changed {
+		return false, nil
+	}
+	p := prompt.Prompt{
+		Text:     "Recreate the KDK container to publish the reassigned ports?  Changes outside of its volumes are lost. [y/n] ",
+		Loop:     true,
+		Validate: prompt.ValidateYorN,
+	}
+	if result, err := p.Run(); err != nil || result == "n" {
+		return false, errors.New("KDK ports were reassigned, but the KDK container publishes the previous ports until it is recreated")
+	}
+	if err := cfg.WriteConfig(); err != nil {
+		return false, fmt.Errorf("failed to write KDK config: %v", err)
+	}
+	log.Info("Removing KDK container to recreate it with the reassigned ports")
+	if err := cfg.DockerClient.ContainerRemove(cfg.Ctx, containerID, types.ContainerRemoveOptions{Force: true}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Returns the ID and docker state of the KDK container, or an empty state if no container exists
+func containerState(cfg KdkEnvConfig) (containerID string, state string, err error) {
+	containerJSON, err := cfg.DockerClient.ContainerInspect(cfg.Ctx, cfg.ConfigFile.AppConfig.Name)
 	if err != nil {
-		log.WithField("error", err).Fatal("Failed to create KDK container")
-	} else {
-		if err := containerStart(cfg, containerID); err != nil {
-			log.WithField("error", err).Fatal("Failed to start KDK container")
+		if client.IsErrNotFound(err) {
+			return "", "", nil
 		}
+		return "", "", err
 	}
-	return nil
+	// Inspect also matches ID prefixes; only an exact name match is the KDK container
+	if containerJSON.Name != "/"+cfg.ConfigFile.AppConfig.Name || containerJSON.State == nil {
+		return "", "", nil
+	}
+	return containerJSON.ID, containerJSON.State.Status, nil
 }
+
 func containerCreate(cfg KdkEnvConfig) (string, error) {
+	containerConfig, hostConfig := containerCreateConfig(cfg)
 	containerCreateResp, err := cfg.DockerClient.ContainerCreate(
 		cfg.Ctx,
-		cfg.ConfigFile.ContainerConfig,
-		cfg.ConfigFile.HostConfig,
+		containerConfig,
+		hostConfig,
 		nil,
 		cfg.ConfigFile.AppConfig.Name,
 	)
 	if err != nil {
 		return "", err
 	}
+	if cfg.IsRemoteEngine() {
+		if err := copyPublicKeyToContainer(cfg, containerCreateResp.ID); err != nil {
+			return "", err
+		}
+	}
+	// sshd starts with the KDK host key, which the host pins
+	files, err := hostKeyFiles(cfg)
+	if err != nil {
+		return "", err
+	}
+	if err := copyToContainer(cfg, containerCreateResp.ID, files...); err != nil {
+		return "", err
+	}
 	return containerCreateResp.ID, nil
 }
 
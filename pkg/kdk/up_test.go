@@ -0,0 +1,367 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cisco-sso/kdk/pkg/prompt"
+	"github.com/cisco-sso/kdk/pkg/utils"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+	"github.com/mitchellh/go-homedir"
+)
+
+// Container known to fakeDockerClient
+type fakeContainer struct {
+	id     string
+	name   string
+	state  string
+	image  string
+	labels map[string]string
+	next   []string // states the container moves through on subsequent inspects
+}
+
+// Docker client holding containers in memory.  Methods that are not
+// implemented panic through the nil embedded client.
+type fakeDockerClient struct {
+	client.APIClient
+	containers []*fakeContainer
+	calls      []string
+	images     []types.ImageSummary
+	failures   map[string]error // error returned by a call, keyed by method or by method and arguments
+	created    int
+}
+
+func (f *fakeDockerClient) call(method string, args ...string) error {
+	call := strings.TrimSpace(method + " " + strings.Join(args, " "))
+	f.calls = append(f.calls, call)
+	if err, ok := f.failures[call]; ok {
+		return err
+	}
+	return f.failures[method]
+}
+
+func (f *fakeDockerClient) find(nameOrID string) *fakeContainer {
+	for _, c := range f.containers {
+		if c.id == nameOrID || c.name == nameOrID {
+			return c
+		}
+	}
+	return nil
+}
+
+func (f *fakeDockerClient) ContainerInspect(ctx context.Context, nameOrID string) (types.ContainerJSON, error) {
+	c := f.find(nameOrID)
+	if c == nil {
+		return types.ContainerJSON{}, errdefs.NotFound(fmt.Errorf("no such container: %s", nameOrID))
+	}
+	containerJSON := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{ID: c.id, Name: "/" + c.name, State: &types.ContainerState{Status: c.state}},
+		Config:            &container.Config{Image: c.image, Labels: c.labels},
+	}
+	if len(c.next) > 0 {
+		c.state, c.next = c.next[0], c.next[1:]
+	}
+	return containerJSON, nil
+}
+
+func (f *fakeDockerClient) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	var containers []types.Container
+	for _, c := range f.containers {
+		if !options.All && c.state != "running" {
+			continue
+		}
+		match := true
+		for _, label := range options.Filters.Get("label") {
+			parts := strings.SplitN(label, "=", 2)
+			if value, ok := c.labels[parts[0]]; !ok || (len(parts) == 2 && value != parts[1]) {
+				match = false
+			}
+		}
+		if match {
+			containers = append(containers, types.Container{ID: c.id, Names: []string{"/" + c.name}, State: c.state, Labels: c.labels})
+		}
+	}
+	return containers, nil
+}
+
+func (f *fakeDockerClient) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, name string) (container.ContainerCreateCreatedBody, error) {
+	if err := f.call("ContainerCreate", name); err != nil {
+		return container.ContainerCreateCreatedBody{}, err
+	}
+	if f.find(name) != nil {
+		return container.ContainerCreateCreatedBody{}, errdefs.Conflict(fmt.Errorf("container name %s is in use", name))
+	}
+	f.created++
+	c := &fakeContainer{id: fmt.Sprintf("new%d", f.created), name: name, state: "created", image: config.Image, labels: config.Labels}
+	f.containers = append(f.containers, c)
+	return container.ContainerCreateCreatedBody{ID: c.id}, nil
+}
+
+func (f *fakeDockerClient) ContainerStart(ctx context.Context, id string, options types.ContainerStartOptions) error {
+	if err := f.call("ContainerStart", id); err != nil {
+		return err
+	}
+	f.find(id).state = "running"
+	return nil
+}
+
+func (f *fakeDockerClient) ContainerStop(ctx context.Context, id string, timeout *time.Duration) error {
+	if err := f.call("ContainerStop", id); err != nil {
+		return err
+	}
+	f.find(id).state = "exited"
+	return nil
+}
+
+func (f *fakeDockerClient) ContainerUnpause(ctx context.Context, id string) error {
+	if err := f.call("ContainerUnpause", id); err != nil {
+		return err
+	}
+	f.find(id).state = "running"
+	return nil
+}
+
+func (f *fakeDockerClient) ContainerRename(ctx context.Context, id, name string) error {
+	if err := f.call("ContainerRename", id, name); err != nil {
+		return err
+	}
+	if f.find(name) != nil {
+		return errdefs.Conflict(fmt.Errorf("container name %s is in use", name))
+	}
+	f.find(id).name = name
+	return nil
+}
+
+func (f *fakeDockerClient) ContainerRemove(ctx context.Context, id string, options types.ContainerRemoveOptions) error {
+	if err := f.call("ContainerRemove", id); err != nil {
+		return err
+	}
+	for i, c := range f.containers {
+		if c.id == id {
+			f.containers = append(f.containers[:i], f.containers[i+1:]...)
+			return nil
+		}
+	}
+	return errdefs.NotFound(fmt.Errorf("no such container: %s", id))
+}
+
+func (f *fakeDockerClient) ContainerExecCreate(ctx context.Context, id string, config types.ExecConfig) (types.IDResponse, error) {
+	if err := f.call("ContainerExecCreate", id); err != nil {
+		return types.IDResponse{}, err
+	}
+	return types.IDResponse{}, errors.New("exec is not supported by the fake docker client")
+}
+
+func (f *fakeDockerClient) ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error) {
+	return f.images, nil
+}
+
+func (f *fakeDockerClient) ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	f.call("ImagePull", ref)
+	return nil, errors.New("pull is not supported by the fake docker client")
+}
+
+func (f *fakeDockerClient) ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+	f.call("ImageBuild", options.Tags...)
+	return types.ImageBuildResponse{}, errors.New("build is not supported by the fake docker client")
+}
+
+func (f *fakeDockerClient) CopyToContainer(ctx context.Context, id, path string, content io.Reader, options types.CopyToContainerOptions) error {
+	return f.call("CopyToContainer", id, path)
+}
+
+// Points HOME at a temporary directory, returning a function that restores it
+func useTempHome(t *testing.T) func() {
+	home, err := ioutil.TempDir("", "kdk-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := os.Getenv("HOME")
+	os.Setenv("HOME", home)
+	homedir.DisableCache = true
+	return func() {
+		os.Setenv("HOME", previous)
+		homedir.DisableCache = false
+		os.RemoveAll(home)
+	}
+}
+
+// KDK config backed by a fake docker client holding the given containers
+func fakeKdkEnvConfig(t *testing.T, containers ...*fakeContainer) (*KdkEnvConfig, *fakeDockerClient) {
+	fake := &fakeDockerClient{containers: containers, failures: map[string]error{}}
+	cfg := &KdkEnvConfig{DockerClient: fake, Ctx: context.Background()}
+	cfg.ConfigFile.AppConfig.Name = "kdk"
+	cfg.ConfigFile.AppConfig.ImageRepository = "ciscosso/kdk"
+	cfg.ConfigFile.AppConfig.ImageTag = "1.0.0"
+	cfg.ConfigFile.ContainerConfig = &container.Config{Image: "ciscosso/kdk:1.0.0", Labels: map[string]string{"kdk": "1.0.0"}}
+	cfg.ConfigFile.HostConfig = &container.HostConfig{PortBindings: nat.PortMap{"2022/tcp": {{HostIP: "127.0.0.1"}}}}
+	cfg.setSSHPort(strconv.Itoa(utils.GetPort()))
+	if err := os.MkdirAll(cfg.ConfigDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return cfg, fake
+}
+
+func TestUpActionForState(t *testing.T) {
+
+	transitions := map[string]upAction{
+		"":           upActionCreate,
+		"running":    upActionNone,
+		"created":    upActionStart,
+		"paused":     upActionUnpause,
+		"restarting": upActionWait,
+		"removing":   upActionWait,
+		"dead":       upActionRemove,
+		"exited":     upActionRestartOrRemove,
+	}
+	for state, expected := range transitions {
+		action, err := upActionForState(state)
+		if err != nil || action != expected {
+			t.Logf("State [%s] maps to action %v (err %v), expected %v.", state, action, err, expected)
+			t.FailNow()
+		}
+	}
+}
+
+func TestUpActionForUnknownState(t *testing.T) {
+
+	if _, err := upActionForState("zombie"); err == nil {
+		t.Log("Unknown container state did not return an error.")
+		t.FailNow()
+	}
+}
+
+func TestUpTransitions(t *testing.T) {
+
+	defer useTempHome(t)()
+
+	tests := []struct {
+		state    string
+		next     []string
+		expected []string
+	}{
+		{"running", nil, nil},
+		{"created", nil, []string{"ContainerStart old"}},
+		{"paused", nil, []string{"ContainerUnpause old"}},
+		{"restarting", []string{"running"}, nil},
+		{"dead", nil, []string{"ContainerRemove old", "ContainerCreate kdk", "CopyToContainer new1 /", "ContainerStart new1"}},
+	}
+	for _, test := range tests {
+		cfg, fake := fakeKdkEnvConfig(t, &fakeContainer{id: "old", name: "kdk", state: test.state, next: test.next})
+		if err := Up(cfg); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(fake.calls, test.expected) {
+			t.Logf("Up of a %s container made calls %v, expected %v.", test.state, fake.calls, test.expected)
+			t.FailNow()
+		}
+		if c := fake.find("kdk"); c == nil || c.state != "running" {
+			t.Logf("KDK container is %+v after Up of a %s container, expected it running.", c, test.state)
+			t.FailNow()
+		}
+	}
+}
+
+func TestUpRestartsExitedContainer(t *testing.T) {
+
+	defer useTempHome(t)()
+	defer func(input io.Reader) { prompt.Input = input }(prompt.Input)
+	prompt.Input = strings.NewReader("y\n")
+
+	cfg, fake := fakeKdkEnvConfig(t, &fakeContainer{id: "old", name: "kdk", state: "exited"})
+	if err := Up(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fake.calls, []string{"ContainerStart old"}) {
+		t.Logf("Up of an exited container made calls %v, expected it restarted.", fake.calls)
+		t.FailNow()
+	}
+}
+
+func TestIsRunningIgnoresPausedContainers(t *testing.T) {
+
+	defer useTempHome(t)()
+
+	cfg, fake := fakeKdkEnvConfig(t, &fakeContainer{id: "old", name: "kdk", state: "paused"})
+	if cfg.IsRunning() {
+		t.Log("Paused KDK container is reported running.")
+		t.FailNow()
+	}
+	fake.find("old").state = "running"
+	if !cfg.IsRunning() {
+		t.Log("Running KDK container is not reported running.")
+		t.FailNow()
+	}
+	fake.containers = []*fakeContainer{{id: "other", name: "kdk-other", state: "running"}}
+	if cfg.IsRunning() {
+		t.Log("Container with a different name is reported as the KDK.")
+		t.FailNow()
+	}
+}
+
+func TestUpRecreatesContainerForReassignedPort(t *testing.T) {
+
+	defer useTempHome(t)()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	busyPort := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	defer func(input io.Reader) { prompt.Input = input }(prompt.Input)
+
+	// Declining to recreate leaves the container as it is
+	cfg, fake := fakeKdkEnvConfig(t, &fakeContainer{id: "old", name: "kdk", state: "exited"})
+	cfg.setSSHPort(busyPort)
+	prompt.Input = strings.NewReader("y\nn\n")
+	if recreate, err := reservePortsForStart(cfg, "old"); err == nil || recreate || len(fake.calls) != 0 {
+		t.Logf("Declined recreation returned %v, %v with calls %v.", recreate, err, fake.calls)
+		t.FailNow()
+	}
+
+	cfg, fake = fakeKdkEnvConfig(t, &fakeContainer{id: "old", name: "kdk", state: "created"})
+	cfg.setSSHPort(busyPort)
+	prompt.Input = strings.NewReader("y\ny\n")
+	if err := Up(cfg); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"ContainerRemove old", "ContainerCreate kdk", "CopyToContainer new1 /", "ContainerStart new1"}
+	if !reflect.DeepEqual(fake.calls, expected) {
+		t.Logf("Up with a busy port made calls %v, expected %v.", fake.calls, expected)
+		t.FailNow()
+	}
+	if cfg.ConfigFile.AppConfig.Port == busyPort || cfg.ConfigFile.HostConfig.PortBindings["2022/tcp"][0].HostPort != cfg.ConfigFile.AppConfig.Port {
+		t.Logf("KDK ssh port is %s with bindings %v, expected it reassigned from %s.",
+			cfg.ConfigFile.AppConfig.Port, cfg.ConfigFile.HostConfig.PortBindings, busyPort)
+		t.FailNow()
+	}
+}
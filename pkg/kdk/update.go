@@ -17,214 +17,325 @@ package kdk
 import (
 	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/cisco-sso/kdk/pkg/utils"
 	"github.com/docker/docker/api/types"
-	"github.com/ghodss/yaml"
 	"github.com/mholt/archiver"
-	"github.com/savaki/jq"
 	log "github.com/sirupsen/logrus"
 )
 
-var (
-	latestReleaseVersion = getLatestReleaseVersion()
+// Release channels followed by `kdk update`
+const (
+	ChannelStable     = "stable"
+	ChannelPrerelease = "prerelease"
 )
 
+var UpdateChannels = []string{ChannelStable, ChannelPrerelease}
+
+// Update settings.  All fields are optional.
+type UpdateSettings struct {
+	Channel string         `json:",omitempty"` // stable | prerelease; defaults to stable
+	Source  *ReleaseSource `json:",omitempty"` // where releases are found; defaults to github.com
+}
+
+// Options of a single `kdk update`
+type UpdateOptions struct {
+	Version  string // release to install instead of the latest release of the channel
+	Channel  string // release channel to follow from now on
+	Rollback bool   // restore the binary, image tag and config in place before the last update
+}
+
+// Update settings, never nil
+func (c *KdkEnvConfig) updateSettings() UpdateSettings {
+	if c.ConfigFile.AppConfig.Update == nil {
+		return UpdateSettings{}
+	}
+	return *c.ConfigFile.AppConfig.Update
+}
+
+// Release channel followed by `kdk update`
+func (c *KdkEnvConfig) UpdateChannel() string {
+	if channel := c.updateSettings().Channel; channel != "" {
+		return channel
+	}
+	return ChannelStable
+}
+
+// Warns, at most once a day, when a newer release is available.  The check
+// uses the cached latest version and refreshes it in the background, so it
+// never blocks the command.
 func WarnIfUpdateAvailable(cfg *KdkEnvConfig) {
-	if latestReleaseVersion == "" {
+	if updateCheckDisabled() {
 		return
 	}
-
-	// Add a sudo hint for unix-based OS's
-	sudo := ""
-	if runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
-		sudo = "sudo " // trailing space is intentional
+	latestVersion := cachedLatestReleaseVersion(cfg)
+	if latestVersion == "" {
+		return
 	}
-	if needsUpdateBin() || needsUpdateImage(cfg) || needsUpdateConfig(cfg) {
+
+	if (needsUpdateBin(latestVersion) || needsUpdateImage(cfg, latestVersion) || needsUpdateConfig(cfg, latestVersion)) && claimUpdateWarning(cfg) {
 		log.Warn("Upgrade Available\n" + strings.Join([]string{
 			"***************************************",
 			"Some KDK components are out of date.",
-			"  Latest Version:                      " + latestReleaseVersion,
+			"  Latest Version:                      " + latestVersion,
 			"  Binary Version:                      " + Version,
 			"  Image Tag:                           " + cfg.ConfigFile.AppConfig.ImageTag,
-			"  Container Present at Config Version: " + strconv.FormatBool(!needsUpdateImage(cfg)),
+			"  Container Present at Config Version: " + strconv.FormatBool(!needsUpdateImage(cfg, latestVersion)),
 			"",
-			"Please upgrade the KDK with the commands:",
-			"  " + sudo + "kdk update",
-			"  kdk destroy",
-			"  kdk ssh",
+			"Please upgrade the KDK with the command:",
+			"  kdk upgrade",
 			"***************************************"}, "\n"))
 	}
 	return
 }
 
 // check if kdk bin needs to be updated
-func needsUpdateBin() bool {
-	return Version != latestReleaseVersion
+func needsUpdateBin(version string) bool {
+	return Version != version
 }
 
 // check if kdk image needs to be updated
-func needsUpdateImage(cfg *KdkEnvConfig) bool {
-	return !hasKdkImageWithTag(cfg, latestReleaseVersion)
+func needsUpdateImage(cfg *KdkEnvConfig, version string) bool {
+	return !hasKdkImageWithTag(cfg, version)
 }
 
 // check if kdk config needs to be updated
-func needsUpdateConfig(cfg *KdkEnvConfig) bool {
-	if cfg.ConfigFile.AppConfig.ImageTag != latestReleaseVersion ||
-		cfg.ConfigFile.ContainerConfig.Image != cfg.ImageCoordinates() ||
-		cfg.ConfigFile.ContainerConfig.Labels["kdk"] != latestReleaseVersion {
+func needsUpdateConfig(cfg *KdkEnvConfig, version string) bool {
+	if cfg.ConfigFile.AppConfig.ImageTag != version ||
+		cfg.ConfigFile.ContainerConfig.Image != cfg.ContainerImageCoordinates() ||
+		cfg.ConfigFile.ContainerConfig.Labels["kdk"] != version {
 		return true
 	}
 	return false
 }
 
-func Update(cfg *KdkEnvConfig) {
-	if latestReleaseVersion == "" {
+// check if the configured extensions image needs to be built
+func needsBuildExtensions(cfg *KdkEnvConfig) bool {
+	if !cfg.HasExtensions() {
+		return false
+	}
+	coordinates := cfg.ContainerImageCoordinates()
+	return !hasKdkImageWithTag(cfg, coordinates[strings.LastIndex(coordinates, ":")+1:])
+}
+
+// Updates the KDK binary, image and config to the latest release of the
+// channel, or to the requested version, or rolls back the last update
+func Update(cfg *KdkEnvConfig, opts UpdateOptions) (result UpdateResult) {
+	if opts.Rollback {
+		return rollbackUpdate(cfg)
+	}
+
+	if opts.Channel != "" && opts.Channel != cfg.UpdateChannel() {
+		if !utils.Contains(UpdateChannels, opts.Channel) {
+			log.Fatalf("Unknown update channel [%s]; expected one of %s", opts.Channel, strings.Join(UpdateChannels, "|"))
+		}
+		settings := cfg.updateSettings()
+		settings.Channel = opts.Channel
+		cfg.ConfigFile.AppConfig.Update = &settings
+		if err := cfg.WriteConfig(); err != nil {
+			log.WithField("error", err).Fatal("Failed to write new config file")
+		}
+		log.WithField("channel", opts.Channel).Info("Switched KDK update channel")
+	}
+
+	version := opts.Version
+	if version == "" {
+		version = refreshUpdateCheck(cfg)
+		result.LatestVersion = version
+	}
+	result.TargetVersion = version
+	if version == "" {
 		log.Warn("Upgrade Unavailable.  Unable to fetch latest version")
-		return
+		return result
 	}
 
-	if !(needsUpdateBin() || needsUpdateImage(cfg) || needsUpdateConfig(cfg)) {
-		log.Warn("Upgrade Unavailable.  Already at latest versions")
-		return
+	if !(needsUpdateBin(version) || needsUpdateImage(cfg, version) || needsUpdateConfig(cfg, version) || needsBuildExtensions(cfg)) {
+		log.Warn("Upgrade Unavailable.  Already at version " + version)
+		return result
 	}
 
-	if needsUpdateBin() {
-		if (runtime.GOOS == "linux" || runtime.GOOS == "darwin") && os.Geteuid() != 0 {
-			log.Fatal("Please execute the update command with `sudo` or as the `root` user")
+	binFile := ""
+	if needsUpdateBin(version) {
+		var err error
+		if binFile, err = cfg.binaryInstallPath(); err != nil {
+			log.WithField("error", err).Fatal("Unable to update KDK binary")
 		}
+	}
 
+	// Record what this update replaces so that it can be rolled back
+	if err := saveRollbackState(cfg); err != nil {
+		log.WithField("error", err).Fatal("Failed to record KDK rollback state")
+	}
+
+	if needsUpdateBin(version) {
 		log.Info("Updating KDK binary")
-		err := updateBin()
+		err := updateBin(cfg, version, binFile)
 		if err != nil {
 			log.WithField("error", err).Fatal("Failed to update KDK bin")
 		}
+		result.BinaryUpdated = true
+		warnIfNotOnPath(binFile)
 	} else {
-		log.Info("Updating KDK binary skipped: Already at latest version")
+		log.Info("Updating KDK binary skipped: Already at version " + version)
 	}
 
-	if needsUpdateImage(cfg) {
+	if needsUpdateImage(cfg, version) {
 		log.Info("Updating KDK container image")
-		err := pullImage(cfg, cfg.ConfigFile.AppConfig.ImageRepository+":"+latestReleaseVersion)
+		err := pullImage(cfg, cfg.ConfigFile.AppConfig.ImageRepository+":"+version)
 		if err != nil {
 			log.WithField("error", err).Fatal("Failed to update KDK image")
 		}
+		result.ImageUpdated = true
 	} else {
-		log.Info("Updating KDK container image skipped: Already at latest version")
+		log.Info("Updating KDK container image skipped: Already at version " + version)
 	}
 
-	if needsUpdateConfig(cfg) {
+	if needsUpdateConfig(cfg, version) {
 		log.Info("Updating KDK config")
-		err := updateConfig(cfg)
+		err := updateConfig(cfg, version)
 		if err != nil {
 			log.WithField("error", err).Fatal("Failed to update KDK config")
 		}
+		result.ConfigUpdated = true
 	} else {
-		log.Info("Updating KDK config skipped: Already at latest version")
+		log.Info("Updating KDK config skipped: Already at version " + version)
 	}
-	return
+
+	// The extensions image is layered on the base image tag, so a new base tag requires a rebuild
+	if needsBuildExtensions(cfg) {
+		log.Info("Building KDK extensions image for base image " + cfg.ImageCoordinates())
+		if err := Build(cfg, false); err != nil {
+			log.WithField("error", err).Fatal("Failed to build KDK extensions image")
+		}
+		result.ImageUpdated = true
+	}
+	return result
 }
 
-// update kdk bin
-func updateBin() error {
+// update kdk bin at kdkBinFile to the release version
+func updateBin(cfg *KdkEnvConfig, version, kdkBinFile string) error {
 	//// Construct all of the paths upfront
 
-	// Calculate the download url
-	baseUrl := "https://github.com/cisco-sso/kdk/releases/download"
-	downloadBaseName := "kdk-" + latestReleaseVersion + "-" + runtime.GOOS + "-" + runtime.GOARCH
-	downloadLink := baseUrl + "/" + latestReleaseVersion + "/" + downloadBaseName + ".tar.gz"
-
-	// Calculate the temporary download and unpacking location
-	tmpDir := filepath.Join(os.TempDir(), "kdk-install")
-	tgzFile := filepath.Join(tmpDir, downloadBaseName+".tar.gz")
+	// Find the release archive
+	source, err := cfg.releaseSource()
+	if err != nil {
+		log.WithField("error", err).Error("Invalid release source")
+		return err
+	}
+	archiveName := releaseArchiveName(version, runtime.GOOS, runtime.GOARCH)
 
-	kdkBinFile, _ := os.Executable() // this currently running binary will be overwritten
-	kdkBinFileUnpacked := filepath.Join(tmpDir, filepath.Base(kdkBinFile))
-	kdkBinFileTrash := filepath.Join(os.TempDir(), filepath.Base(kdkBinFile)+".old")
-	// ^ Some filesystems do not allow replacing or deleting a currently
-	//   running binary.  We'll move it out of the way instead of deletion
+	// Create a private temporary download and unpacking location
+	tmpDir, err := ioutil.TempDir("", "kdk-install")
+	if err != nil {
+		log.WithField("error", err).Error("Failed to create temporary directory")
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
 
-	log.WithField("file", kdkBinFile).Info("Bin File Location")
+	kdkBinFileUnpacked := filepath.Join(tmpDir, binaryName())
 
-	//// download tgz file to the tmp dir
-	err := downloadFile(downloadLink, tmpDir, tgzFile)
+	//// download the release archive, verifying it before anything is replaced
+	tgzFile, err := downloadVerifiedRelease(source, version, archiveName, tmpDir, pinnedReleasePublicKey())
 	if err != nil {
-		log.WithField("error", err).WithField("file", tgzFile).WithField("url", downloadLink).Fatal("Failed to download file")
+		log.WithField("error", err).WithField("file", archiveName).Error("Failed to download and verify release; KDK binary left unchanged")
+		return err
 	}
-	log.WithField("file", tgzFile).WithField("url", downloadLink).Info("Successfully downloaded file")
+	log.WithField("file", tgzFile).Info("Successfully downloaded file")
 
 	// extract tgz
 	err = archiver.TarGz.Open(tgzFile, tmpDir)
 	if err != nil {
-		log.WithField("error", err).WithField("file", tgzFile).Fatal("Failed to extract tgz")
+		log.WithField("error", err).WithField("file", tgzFile).Error("Failed to extract tgz")
 		return err
 	}
 	log.WithField("file", tgzFile).Info("Successfully extracted tgz file")
 
+	return installBinary(cfg, kdkBinFileUnpacked, kdkBinFile)
+}
+
+// Installs newBinFile as the kdk binary at kdkBinFile.  The running binary is
+// first kept under ~/.kdk/bin so that the replacement can be rolled back.
+func installBinary(cfg *KdkEnvConfig, newBinFile, kdkBinFile string) error {
+	kdkBinFileTrash := filepath.Join(os.TempDir(), filepath.Base(kdkBinFile)+".old")
+	// ^ Some filesystems do not allow replacing or deleting a currently
+	//   running binary.  We'll move it out of the way instead of deletion
+
+	log.WithField("file", kdkBinFile).Info("Bin File Location")
+
+	// keep the running binary, unless it is the one being restored or installed
+	runningBinFile, _ := runningBinaryPath()
+	archivedBinFile := cfg.ArchivedBinaryPath(Version)
+	if newBinFile != archivedBinFile && newBinFile != runningBinFile {
+		if err := os.MkdirAll(cfg.BinDir(), 0755); err != nil {
+			log.WithField("error", err).WithField("dir", cfg.BinDir()).Error("Failed to create directory")
+			return err
+		}
+		if err := copyFile(runningBinFile, archivedBinFile); err != nil {
+			log.WithField("error", err).WithField("fileSrc", runningBinFile).WithField("fileDst", archivedBinFile).Error("Failed to copy file")
+			return err
+		}
+		if err := os.Chmod(archivedBinFile, 0755); err != nil {
+			log.WithField("error", err).WithField("file", archivedBinFile).Error("Failed to chmod file")
+			return err
+		}
+		log.WithField("file", archivedBinFile).Info("Successfully kept previous KDK binary")
+	}
+
+	// The install directory, e.g. $XDG_BIN_HOME, may not exist yet
+	if err := os.MkdirAll(filepath.Dir(kdkBinFile), 0755); err != nil {
+		log.WithField("error", err).WithField("dir", filepath.Dir(kdkBinFile)).Error("Failed to create directory")
+		return err
+	}
+
 	if runtime.GOOS == "darwin" || runtime.GOOS == "linux" {
 		// copy the new file next to the org binary, so it is on the same partition/filesystem so that moves work
-		err = copyFile(kdkBinFileUnpacked, kdkBinFile+".new")
+		err := copyFile(newBinFile, kdkBinFile+".new")
 		if err != nil {
-			log.WithField("error", err).WithField("fileSrc", kdkBinFileUnpacked).WithField("fileDst", kdkBinFile+".new").Fatal("Failed to copy file")
+			log.WithField("error", err).WithField("fileSrc", newBinFile).WithField("fileDst", kdkBinFile+".new").Error("Failed to copy file")
 			return err
 		}
-		log.WithField("fileSrc", kdkBinFileUnpacked).WithField("fileDst", kdkBinFile+".new").Info("Successfully copied file")
+		log.WithField("fileSrc", newBinFile).WithField("fileDst", kdkBinFile+".new").Info("Successfully copied file")
 
 		// set the copy to be executable
 		err = os.Chmod(kdkBinFile+".new", 0755)
 		if err != nil {
-			log.WithField("error", err).WithField("file", kdkBinFile+".new").Fatal("Failed to chmod file")
+			log.WithField("error", err).WithField("file", kdkBinFile+".new").Error("Failed to chmod file")
 			return err
 		}
 		log.WithField("file", kdkBinFile+".new").Info("Successfully chmod'd file")
 
-		// remove the original bin file
-		err = os.Remove(kdkBinFile)
-		if err != nil {
-			log.WithField("error", err).WithField("file", kdkBinFile).Fatal("Failed to delete file")
-		}
-		log.WithField("file", kdkBinFile).Info("Successfully deleted file")
-
-		// rename the new file to be the the executable file
+		// rename the new file over the executable file
 		err = os.Rename(kdkBinFile+".new", kdkBinFile)
 		if err != nil {
-			log.WithField("error", err).WithField("fileSrc", kdkBinFile+".new").WithField("fileDst", kdkBinFile).Fatal("Failed to rename file")
+			log.WithField("error", err).WithField("fileSrc", kdkBinFile+".new").WithField("fileDst", kdkBinFile).Error("Failed to rename file")
+			return err
 		}
 		log.WithField("fileSrc", kdkBinFile+".new").WithField("fileDst", kdkBinFile).Info("Successfully renamed file")
 	} else if runtime.GOOS == "windows" {
 		// rename the bin file to a trash location out of the way
-		err = os.Rename(kdkBinFile, kdkBinFileTrash)
-		if err != nil {
-			log.WithField("error", err).WithField("fileSrc", kdkBinFile).WithField("fileDst", kdkBinFileTrash).Fatal("Failed to rename file")
+		err := os.Rename(kdkBinFile, kdkBinFileTrash)
+		if err != nil && !os.IsNotExist(err) {
+			log.WithField("error", err).WithField("fileSrc", kdkBinFile).WithField("fileDst", kdkBinFileTrash).Error("Failed to rename file")
+			return err
 		}
 		log.WithField("fileSrc", kdkBinFile).WithField("fileDst", kdkBinFileTrash).Info("Successfully renamed file")
 
 		// copy the new file next to the org binary, so it is on the same partition/filesystem
-		err = copyFile(kdkBinFileUnpacked, kdkBinFile)
+		err = copyFile(newBinFile, kdkBinFile)
 		if err != nil {
-			log.WithField("error", err).WithField("fileSrc", kdkBinFileUnpacked).WithField("fileDst", kdkBinFile).Fatal("Failed to copy file")
+			log.WithField("error", err).WithField("fileSrc", newBinFile).WithField("fileDst", kdkBinFile).Error("Failed to copy file")
 			return err
 		}
-		log.WithField("fileSrc", kdkBinFileUnpacked).WithField("fileDst", kdkBinFile).Info("Successfully copied file")
+		log.WithField("fileSrc", newBinFile).WithField("fileDst", kdkBinFile).Info("Successfully copied file")
 	} else {
 		log.Fatal("Unhandled code path")
 	}
 
-	// remove temp dir
-	err = os.RemoveAll(tmpDir)
-	if err != nil {
-		log.WithField("error", err).WithField("dir", tmpDir).Error("Failed to remove directory")
-		return err
-	}
-	log.WithField("dir", tmpDir).Info("Successfully removed directory")
-
 	return nil
 }
 
@@ -234,19 +345,19 @@ func updateImage(cfg *KdkEnvConfig) error {
 	return nil
 }
 
-// update kdk config
-func updateConfig(cfg *KdkEnvConfig) error {
-	cfg.ConfigFile.AppConfig.ImageTag = latestReleaseVersion
-	cfg.ConfigFile.ContainerConfig.Labels["kdk"] = latestReleaseVersion
-	cfg.ConfigFile.ContainerConfig.Image = cfg.ImageCoordinates()
+// point the config at the release version.  The extensions image tag is
+// derived from the base image tag, so it is computed after the tag changes.
+func setConfigVersion(cfg *KdkEnvConfig, version string) {
+	cfg.ConfigFile.AppConfig.ImageTag = version
+	cfg.ConfigFile.ContainerConfig.Labels["kdk"] = version
+	cfg.ConfigFile.ContainerConfig.Image = cfg.ContainerImageCoordinates()
+}
 
-	y, err := yaml.Marshal(cfg.ConfigFile)
-	if err != nil {
-		log.WithField("error", err).Error("Failed to create YAML string of configuration")
-		return err
-	}
+// update kdk config to the release version
+func updateConfig(cfg *KdkEnvConfig, version string) error {
+	setConfigVersion(cfg, version)
 
-	err = ioutil.WriteFile(cfg.ConfigPath(), y, 0600)
+	err := cfg.WriteConfig()
 	if err != nil {
 		log.WithField("error", err).Error("Failed to write new config file")
 		return err
@@ -255,32 +366,6 @@ func updateConfig(cfg *KdkEnvConfig) error {
 	return nil
 }
 
-func getLatestReleaseVersion() string {
-	client := http.Client{
-		Timeout: time.Duration(3 * time.Second),
-	}
-
-	// Fetch the informational json blob
-	resp, err := client.Get("https://api.github.com/repos/cisco-sso/kdk/releases/latest")
-	if err != nil {
-		return ""
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-
-	// Parse the latest tag name
-	op, _ := jq.Parse(".tag_name")
-	version, err := op.Apply([]byte(string(body)))
-	if err != nil {
-		log.WithField("error", err).Error("Failed to check latest release version", err)
-		return ""
-	}
-
-	// Remove the bookend quotes
-	versionStr := strings.Replace(string(version), "\"", "", -1)
-	return versionStr
-}
-
 // get kdk docker image on host
 func getKdkImages(cfg *KdkEnvConfig) (out []types.ImageSummary) {
 	var kdkImages []types.ImageSummary
@@ -337,39 +422,3 @@ func copyFile(src, dst string) error {
 	}
 	return out.Close()
 }
-
-func downloadFile(url string, dir string, file string) error {
-	// Open the TCP stream for downloading download latest tgz release
-	resp, err := http.Get(url)
-	if err != nil {
-		log.WithField("error", err).WithField("url", url).Error("Failed to reach Url")
-		return err
-	}
-	defer resp.Body.Close()
-
-	// create dir if it doesn't exist
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		err = os.MkdirAll(dir, 0777)
-		if err != nil {
-			log.WithField("error", err).WithField("dir", dir).Error("Failed to create directory")
-			return err
-		}
-	}
-
-	// open file file for eventual writing
-	fd, err := os.Create(file)
-	if err != nil {
-		log.WithField("error", err).WithField("file", file).Error("Failed to open file for writing")
-		return err
-	}
-	defer fd.Close()
-
-	// write the TCP stream to the file
-	_, err = io.Copy(fd, resp.Body)
-	if err != nil {
-		log.WithField("error", err).WithField("file", file).Errorf("Failed to write to file")
-		return err
-	}
-
-	return nil
-}
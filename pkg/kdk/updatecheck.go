@@ -0,0 +1,204 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Environment variable that disables the update check when set to a true value
+const NoUpdateCheckEnv = "KDK_NO_UPDATE_CHECK"
+
+const (
+	// How long a successful update check is trusted
+	updateCheckTTL = 24 * time.Hour
+
+	// Minimum interval between update check attempts, successful or not
+	updateCheckRetryInterval = time.Hour
+
+	// Minimum interval between warnings that an update is available
+	updateWarningInterval = 24 * time.Hour
+)
+
+// Results of previous update checks (~/.kdk/update-check.json)
+type updateCheckCache struct {
+	Checks      map[string]updateCheck `json:"checks"` // by release source and channel
+	LastWarning time.Time              `json:"lastWarning"`
+}
+
+type updateCheck struct {
+	LatestVersion string    `json:"latestVersion"`
+	CheckedAt     time.Time `json:"checkedAt"`   // last successful check
+	AttemptedAt   time.Time `json:"attemptedAt"` // last check, successful or not
+}
+
+var (
+	// Serializes updates of the cache file within this process
+	updateCheckMutex sync.Mutex
+
+	// Closed when the background update check finishes
+	updateCheckDone chan struct{}
+)
+
+// kdk update check cache path (~/.kdk/update-check.json)
+func (c *KdkEnvConfig) UpdateCheckPath() (out string) {
+	return filepath.Join(c.ConfigRootDir(), "update-check.json")
+}
+
+// check if update checks are disabled through the environment
+func updateCheckDisabled() bool {
+	value := os.Getenv(NoUpdateCheckEnv)
+	if value == "" {
+		return false
+	}
+	disabled, err := strconv.ParseBool(value)
+	return err != nil || disabled
+}
+
+// Cache key of the release source and channel followed
+func updateCheckKey(cfg *KdkEnvConfig) string {
+	source := ReleaseSource{}
+	if settings := cfg.updateSettings(); settings.Source != nil {
+		source = *settings.Source
+	}
+	return fmt.Sprintf("%s|%s|%s|%s", source.Type, source.URL, source.Repository, cfg.UpdateChannel())
+}
+
+func loadUpdateCheckCache(path string) updateCheckCache {
+	cache := updateCheckCache{}
+	if data, err := ioutil.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &cache); err != nil {
+			log.WithField("error", err).Debug("Ignoring corrupt update check cache")
+		}
+	}
+	if cache.Checks == nil {
+		cache.Checks = map[string]updateCheck{}
+	}
+	return cache
+}
+
+// Writes the cache through a temporary file so that concurrent kdk commands never read a partial file
+func (cache updateCheckCache) save(path string) error {
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp" + strconv.Itoa(os.Getpid())
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Applies change to the cache file
+func updateCheckCacheChange(path string, change func(*updateCheckCache)) {
+	updateCheckMutex.Lock()
+	defer updateCheckMutex.Unlock()
+	cache := loadUpdateCheckCache(path)
+	change(&cache)
+	if err := cache.save(path); err != nil {
+		log.WithField("error", err).Debug("Failed to save update check cache")
+	}
+}
+
+// check if a cached update check should be refreshed
+func (check updateCheck) stale(now time.Time) bool {
+	return now.Sub(check.CheckedAt) > updateCheckTTL && now.Sub(check.AttemptedAt) > updateCheckRetryInterval
+}
+
+// Fetches the latest release version of the channel followed and records it in the cache
+func refreshUpdateCheck(cfg *KdkEnvConfig) string {
+	source, err := cfg.releaseSource()
+	if err != nil {
+		log.WithField("error", err).Error("Invalid release source")
+		return ""
+	}
+	return checkLatestVersion(cfg.UpdateCheckPath(), updateCheckKey(cfg), source, cfg.UpdateChannel())
+}
+
+// The attempt is recorded first, so that a check cut short by kdk exiting is
+// not retried by every following command.
+func checkLatestVersion(path, key string, source releaseSource, channel string) string {
+	updateCheckCacheChange(path, func(cache *updateCheckCache) {
+		check := cache.Checks[key]
+		check.AttemptedAt = time.Now()
+		cache.Checks[key] = check
+	})
+
+	version, err := source.latestVersion(channel)
+	if err != nil {
+		log.WithField("error", err).Debug("Failed to check latest release version")
+		return ""
+	}
+	updateCheckCacheChange(path, func(cache *updateCheckCache) {
+		check := cache.Checks[key]
+		check.LatestVersion = version
+		check.CheckedAt = time.Now()
+		cache.Checks[key] = check
+	})
+	return version
+}
+
+// Returns the cached latest release version, refreshing it in the background
+// when stale.  The refresh result is used by the next command.
+func cachedLatestReleaseVersion(cfg *KdkEnvConfig) string {
+	path, key := cfg.UpdateCheckPath(), updateCheckKey(cfg)
+	check := loadUpdateCheckCache(path).Checks[key]
+	if check.stale(time.Now()) && updateCheckDone == nil {
+		source, err := cfg.releaseSource()
+		if err != nil {
+			log.WithField("error", err).Error("Invalid release source")
+			return check.LatestVersion
+		}
+		channel := cfg.UpdateChannel()
+		updateCheckDone = make(chan struct{})
+		go func() {
+			defer close(updateCheckDone)
+			checkLatestVersion(path, key, source, channel)
+		}()
+	}
+	return check.LatestVersion
+}
+
+// Waits up to timeout for a background update check to record its result
+func WaitForUpdateCheck(timeout time.Duration) {
+	if updateCheckDone == nil {
+		return
+	}
+	select {
+	case <-updateCheckDone:
+	case <-time.After(timeout):
+	}
+}
+
+// Records that an update warning was shown, returning false if one was already shown recently
+func claimUpdateWarning(cfg *KdkEnvConfig) (claimed bool) {
+	updateCheckCacheChange(cfg.UpdateCheckPath(), func(cache *updateCheckCache) {
+		if time.Since(cache.LastWarning) >= updateWarningInterval {
+			cache.LastWarning = time.Now()
+			claimed = true
+		}
+	})
+	return claimed
+}
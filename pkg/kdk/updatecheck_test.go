@@ -0,0 +1,86 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Release source answering with a fixed latest version or error
+type fakeReleaseSource struct {
+	version string
+	err     error
+}
+
+func (s fakeReleaseSource) latestVersion(channel string) (string, error) {
+	return s.version, s.err
+}
+
+func (s fakeReleaseSource) open(version, name string) (io.ReadCloser, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestCheckLatestVersionCachesResult(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "kdk-update-check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "update-check.json")
+
+	if version := checkLatestVersion(path, "stable", fakeReleaseSource{version: "1.2.3"}, ChannelStable); version != "1.2.3" {
+		t.Logf("Latest version is %q, expected 1.2.3.", version)
+		t.FailNow()
+	}
+	check := loadUpdateCheckCache(path).Checks["stable"]
+	if check.LatestVersion != "1.2.3" || check.stale(time.Now()) {
+		t.Logf("Cached check is %+v, expected a fresh check of 1.2.3.", check)
+		t.FailNow()
+	}
+
+	// A failed check of an expired result keeps the last known version and is not retried right away
+	updateCheckCacheChange(path, func(cache *updateCheckCache) {
+		cache.Checks["stable"] = updateCheck{LatestVersion: "1.2.3", CheckedAt: time.Now().Add(-2 * updateCheckTTL)}
+	})
+	checkLatestVersion(path, "stable", fakeReleaseSource{err: errors.New("offline")}, ChannelStable)
+	check = loadUpdateCheckCache(path).Checks["stable"]
+	if check.LatestVersion != "1.2.3" || check.stale(time.Now()) {
+		t.Logf("Cached check is %+v, expected 1.2.3 that is not stale right after an attempt.", check)
+		t.FailNow()
+	}
+	if !check.stale(time.Now().Add(updateCheckRetryInterval + time.Minute)) {
+		t.Log("Cached check is not stale after the retry interval passed.")
+		t.FailNow()
+	}
+}
+
+func TestUpdateCheckDisabled(t *testing.T) {
+
+	defer os.Setenv(NoUpdateCheckEnv, os.Getenv(NoUpdateCheckEnv))
+	for value, expected := range map[string]bool{"": false, "0": false, "false": false, "1": true, "true": true, "yes": true} {
+		os.Setenv(NoUpdateCheckEnv, value)
+		if updateCheckDisabled() != expected {
+			t.Logf("%s=%q disables update checks: %v, expected %v.", NoUpdateCheckEnv, value, !expected, expected)
+			t.FailNow()
+		}
+	}
+}
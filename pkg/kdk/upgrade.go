@@ -0,0 +1,320 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cisco-sso/kdk/pkg/secrets"
+	"github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// Options of `kdk upgrade`
+type UpgradeOptions struct {
+	Version      string // release to upgrade to instead of the latest release
+	Snapshot     bool   // snapshot the KDK container before replacing it
+	PreserveHome bool   // copy the KDK user home directory into the new container
+}
+
+// Suffix of the name under which the previous KDK container is kept during an upgrade
+const previousContainerSuffix = "-pre-upgrade"
+
+// How long the previous KDK container is given to stop
+var upgradeStopTimeout = 30 * time.Second
+
+// Steps of an upgrade that have been carried out and must be undone if a later step fails
+type upgradeProgress struct {
+	previous          configFile // config before the upgrade
+	binaryReplaced    string     // path of the kdk binary once it is replaced
+	previousContainer string     // ID of the previous container once it is stopped
+	previousRunning   bool       // whether the previous container ran before it was stopped
+	previousRenamed   bool       // whether the previous container was renamed out of the way
+	newContainer      string     // ID of the new container once it is created
+}
+
+// Upgrades the binary, image and config, then replaces the KDK container with
+// one created from the new image and config.  If any step fails, the previous
+// binary, config and container are restored.
+func Upgrade(cfg *KdkEnvConfig, opts UpgradeOptions) (result UpgradeResult, err error) {
+	version := opts.Version
+	if version == "" {
+		version = refreshUpdateCheck(cfg)
+		if version == "" {
+			return result, errors.New("unable to fetch latest version")
+		}
+	}
+	result.PreviousVersion = Version
+	result.Version = version
+
+	containerID, state, err := containerState(*cfg)
+	if err != nil {
+		return result, err
+	}
+	outdatedContainer := containerID != "" && containerImage(*cfg, containerID) != cfg.ContainerImageCoordinates()
+	if !(needsUpdateBin(version) || needsUpdateImage(cfg, version) || needsUpdateConfig(cfg, version) || needsBuildExtensions(cfg) || outdatedContainer) {
+		log.Info("KDK is already at version " + version)
+		return result, nil
+	}
+	binFile := ""
+	if needsUpdateBin(version) {
+		if binFile, err = cfg.binaryInstallPath(); err != nil {
+			return result, err
+		}
+	}
+	if _, err := cfg.DockerClient.ContainerInspect(cfg.Ctx, cfg.previousContainerName()); err == nil {
+		return result, fmt.Errorf("a container from an earlier upgrade exists; remove it with `docker rm -f %s` once its content is no longer needed", cfg.previousContainerName())
+	}
+
+	if err := saveRollbackState(cfg); err != nil {
+		return result, fmt.Errorf("failed to record KDK rollback state: %v", err)
+	}
+	// The recorded state is a deep copy of the config, which the upgrade changes in place
+	recorded, err := loadRollbackState(cfg)
+	if err != nil {
+		return result, fmt.Errorf("failed to read KDK rollback state: %v", err)
+	}
+	progress := upgradeProgress{previous: recorded.ConfigFile}
+	defer func() {
+		if err != nil {
+			log.WithField("error", err).Error("KDK upgrade failed.  Rolling back...")
+			if rollbackErr := progress.rollback(cfg); rollbackErr != nil {
+				log.WithField("error", rollbackErr).Error("Failed to roll back KDK upgrade")
+			} else {
+				result.RolledBack = true
+				log.Info("Rolled back KDK upgrade")
+			}
+		}
+		// The previous container may be left stopped, so the secrets filesystem may no longer be in use
+		if releaseErr := ReleaseSecrets(*cfg); releaseErr != nil {
+			log.WithField("error", releaseErr).Warn("Failed to release secrets filesystem")
+		}
+	}()
+
+	if needsUpdateBin(version) {
+		log.Info("Upgrading KDK binary")
+		if err := updateBin(cfg, version, binFile); err != nil {
+			return result, fmt.Errorf("failed to update KDK binary: %v", err)
+		}
+		progress.binaryReplaced = binFile
+		result.BinaryUpdated = true
+		warnIfNotOnPath(binFile)
+	}
+
+	if needsUpdateImage(cfg, version) {
+		log.Info("Pulling KDK container image " + version)
+		if err := pullImage(cfg, cfg.ConfigFile.AppConfig.ImageRepository+":"+version); err != nil {
+			return result, fmt.Errorf("failed to pull KDK image: %v", err)
+		}
+		result.ImageUpdated = true
+	}
+
+	if needsUpdateConfig(cfg, version) {
+		log.Info("Upgrading KDK config")
+		if err := updateConfig(cfg, version); err != nil {
+			return result, fmt.Errorf("failed to update KDK config: %v", err)
+		}
+		if err := cfg.MigrateConfig(); err != nil {
+			return result, fmt.Errorf("failed to migrate KDK config: %v", err)
+		}
+		result.ConfigUpdated = true
+	}
+
+	if needsBuildExtensions(cfg) {
+		log.Info("Building KDK extensions image for base image " + cfg.ImageCoordinates())
+		if err := Build(cfg, false); err != nil {
+			return result, fmt.Errorf("failed to build KDK extensions image: %v", err)
+		}
+		result.ImageUpdated = true
+	}
+
+	if containerID != "" {
+		if opts.Snapshot {
+			if state != "running" {
+				return result, errors.New("the KDK container must be running to snapshot it; start it with `kdk up`")
+			}
+			if result.Snapshot, err = Snapshot(*cfg); err != nil {
+				return result, err
+			}
+		}
+
+		// The previous container is kept, stopped, until the new one is ready
+		log.Info("Stopping previous KDK container")
+		if err := cfg.DockerClient.ContainerStop(cfg.Ctx, containerID, &upgradeStopTimeout); err != nil {
+			return result, fmt.Errorf("failed to stop KDK container: %v", err)
+		}
+		progress.previousContainer = containerID
+		progress.previousRunning = state == "running" || state == "paused"
+		if err := cfg.DockerClient.ContainerRename(cfg.Ctx, containerID, cfg.previousContainerName()); err != nil {
+			return result, fmt.Errorf("failed to rename KDK container: %v", err)
+		}
+		progress.previousRenamed = true
+	}
+
+	// The secrets filesystem must be available before it is bind mounted, as in Up
+	if err := secrets.Start(cfg.ConfigRootDir(), cfg.ConfigFile.AppConfig.Secrets); err != nil {
+		return result, fmt.Errorf("failed to start secrets filesystem: %v", err)
+	}
+	log.Info("Creating upgraded KDK container")
+	progress.newContainer, err = createAndStartContainer(cfg)
+	if err != nil {
+		return result, err
+	}
+	if err := provision(*cfg); err != nil {
+		return result, fmt.Errorf("failed to provision KDK user: %v", err)
+	}
+	if progress.previousContainer != "" && opts.PreserveHome {
+		log.Info("Copying KDK user home directory from previous container")
+		if err := copyHome(*cfg, progress.previousContainer, progress.newContainer); err != nil {
+			return result, fmt.Errorf("failed to copy KDK user home directory: %v", err)
+		}
+	}
+	if err := cfg.WaitReady(); err != nil {
+		return result, err
+	}
+	result.ContainerRecreated = true
+
+	if progress.previousContainer != "" {
+		if err := cfg.DockerClient.ContainerRemove(cfg.Ctx, progress.previousContainer, types.ContainerRemoveOptions{}); err != nil {
+			log.WithField("error", err).Warnf("Failed to remove previous KDK container [%s]", cfg.previousContainerName())
+		}
+	}
+	log.Info("KDK upgraded to version " + version)
+	return result, nil
+}
+
+// Name under which the previous KDK container is kept during an upgrade
+func (c *KdkEnvConfig) previousContainerName() string {
+	return c.ConfigFile.AppConfig.Name + previousContainerSuffix
+}
+
+// Image a container was created from
+func containerImage(cfg KdkEnvConfig, containerID string) string {
+	containerJSON, err := cfg.DockerClient.ContainerInspect(cfg.Ctx, containerID)
+	if err != nil || containerJSON.Config == nil {
+		return ""
+	}
+	return containerJSON.Config.Image
+}
+
+// Undoes the completed steps of a failed upgrade
+func (p upgradeProgress) rollback(cfg *KdkEnvConfig) error {
+	var errs []string
+	if p.newContainer != "" {
+		if err := cfg.DockerClient.ContainerRemove(cfg.Ctx, p.newContainer, types.ContainerRemoveOptions{Force: true}); err != nil {
+			errs = append(errs, "remove upgraded container: "+err.Error())
+		}
+	}
+	if p.previousContainer != "" {
+		restored := true
+		if p.previousRenamed {
+			if err := cfg.DockerClient.ContainerRename(cfg.Ctx, p.previousContainer, cfg.ConfigFile.AppConfig.Name); err != nil {
+				errs = append(errs, "rename previous container: "+err.Error())
+				restored = false
+			}
+		}
+		if restored && p.previousRunning {
+			if err := containerStart(*cfg, p.previousContainer); err != nil {
+				errs = append(errs, "start previous container: "+err.Error())
+			}
+		}
+	}
+	// Container creation may also have reassigned ports in the config
+	cfg.ConfigFile = p.previous
+	if err := cfg.WriteConfig(); err != nil {
+		errs = append(errs, "restore config: "+err.Error())
+	}
+	if p.binaryReplaced != "" {
+		if err := installBinary(cfg, cfg.ArchivedBinaryPath(Version), p.binaryReplaced); err != nil {
+			errs = append(errs, "restore binary: "+err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Copies the KDK user home directory from one container into another.  Paths
+// mounted into the KDK are skipped since they are not part of the container.
+func copyHome(cfg KdkEnvConfig, fromContainer, toContainer string) error {
+	home := path.Join("/home", cfg.User())
+	content, _, err := cfg.DockerClient.CopyFromContainer(cfg.Ctx, fromContainer, home)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	var mountTargets []string
+	for _, m := range cfg.ConfigFile.HostConfig.Mounts {
+		mountTargets = append(mountTargets, m.Target)
+	}
+	for _, bind := range cfg.ConfigFile.HostConfig.Binds {
+		if parts := strings.Split(bind, ":"); len(parts) > 1 {
+			mountTargets = append(mountTargets, parts[1])
+		}
+	}
+	for target := range cfg.ConfigFile.ContainerConfig.Volumes {
+		mountTargets = append(mountTargets, target)
+	}
+	skip := func(name string) bool {
+		containerPath := path.Join("/home", name)
+		for _, target := range mountTargets {
+			if containerPath == target || strings.HasPrefix(containerPath, strings.TrimSuffix(target, "/")+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	return cfg.DockerClient.CopyToContainer(cfg.Ctx, toContainer, "/home", filterTar(content, skip), types.CopyToContainerOptions{CopyUIDGID: true})
+}
+
+// Streams a tar archive without the entries for which skip returns true
+func filterTar(r io.Reader, skip func(name string) bool) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		tr := tar.NewReader(r)
+		tw := tar.NewWriter(pw)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				pw.CloseWithError(tw.Close())
+				return
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if skip(strings.TrimSuffix(header.Name, "/")) {
+				continue
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	return pr
+}
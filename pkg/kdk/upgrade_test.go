@@ -0,0 +1,198 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/ghodss/yaml"
+)
+
+func TestFilterTarSkipsMountedPaths(t *testing.T) {
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range []string{"user/", "user/.bashrc", "user/Projects/", "user/Projects/main.go", "user/ProjectsOld/notes"} {
+		header := &tar.Header{Name: name, Mode: 0644, Typeflag: tar.TypeReg}
+		content := "content of " + name
+		if strings.HasSuffix(name, "/") {
+			header.Typeflag = tar.TypeDir
+			content = ""
+		}
+		header.Size = int64(len(content))
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+
+	skip := func(name string) bool { return name == "user/Projects" || strings.HasPrefix(name, "user/Projects/") }
+	tr := tar.NewReader(filterTar(&buf, skip))
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content := new(bytes.Buffer)
+		content.ReadFrom(tr)
+		if header.Typeflag == tar.TypeReg && content.String() != "content of "+header.Name {
+			t.Logf("Content of %s is %q.", header.Name, content)
+			t.FailNow()
+		}
+		names = append(names, header.Name)
+	}
+
+	expected := []string{"user/", "user/.bashrc", "user/ProjectsOld/notes"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Logf("Filtered entries are %v, expected %v.", names, expected)
+		t.FailNow()
+	}
+}
+
+// KDK at version 1.0.0 with a running container, upgradable to the locally present 1.1.0 image
+func upgradeTestConfig(t *testing.T) (*KdkEnvConfig, *fakeDockerClient) {
+	cfg, fake := fakeKdkEnvConfig(t, &fakeContainer{id: "old", name: "kdk", state: "running", image: "ciscosso/kdk:1.0.0"})
+	fake.images = []types.ImageSummary{{RepoTags: []string{"ciscosso/kdk:1.1.0"}, Labels: map[string]string{"kdk": "1.1.0"}}}
+	if err := cfg.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg, fake
+}
+
+func TestUpgradeRollsBackEachFailedStep(t *testing.T) {
+
+	defer useTempHome(t)()
+	defer func(version string) { Version = version }(Version)
+	Version = "1.1.0"
+
+	failures := []string{
+		"ContainerStop old",
+		"ContainerRename old kdk-pre-upgrade",
+		"ContainerCreate kdk",
+		"ContainerStart new1",
+		"ContainerExecCreate kdk", // provisioning
+	}
+	for _, failure := range failures {
+		cfg, fake := upgradeTestConfig(t)
+		fake.failures[failure] = errors.New("injected failure")
+
+		result, err := Upgrade(cfg, UpgradeOptions{Version: "1.1.0"})
+		if err == nil || !result.RolledBack {
+			t.Logf("Upgrade failing at [%s] returned %+v, %v; expected a rolled back error.", failure, result, err)
+			t.FailNow()
+		}
+		if c := fake.containers[0]; len(fake.containers) != 1 || c.id != "old" || c.name != "kdk" || c.state != "running" {
+			t.Logf("Upgrade failing at [%s] left containers %+v, expected only the previous one, running as kdk.", failure, fake.containers)
+			t.FailNow()
+		}
+		data, err := ioutil.ReadFile(cfg.ConfigPath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var saved configFile
+		if err := yaml.Unmarshal(data, &saved); err != nil {
+			t.Fatal(err)
+		}
+		if saved.AppConfig.ImageTag != "1.0.0" || saved.ContainerConfig.Image != "ciscosso/kdk:1.0.0" || cfg.ConfigFile.AppConfig.ImageTag != "1.0.0" {
+			t.Logf("Upgrade failing at [%s] left config at %s (%s), expected 1.0.0.", failure, saved.AppConfig.ImageTag, saved.ContainerConfig.Image)
+			t.FailNow()
+		}
+	}
+}
+
+func TestUpgradeRollbackKeepsStoppedContainerStopped(t *testing.T) {
+
+	defer useTempHome(t)()
+	defer func(version string) { Version = version }(Version)
+	Version = "1.1.0"
+
+	cfg, fake := upgradeTestConfig(t)
+	fake.find("old").state = "exited"
+	fake.failures["ContainerExecCreate"] = errors.New("injected failure")
+	if result, err := Upgrade(cfg, UpgradeOptions{Version: "1.1.0"}); err == nil || !result.RolledBack {
+		t.Logf("Failed upgrade returned %+v, %v; expected a rolled back error.", result, err)
+		t.FailNow()
+	}
+	if c := fake.find("kdk"); c == nil || c.id != "old" || c.state != "exited" {
+		t.Logf("Previous container is %+v after rollback, expected it exited as kdk.", c)
+		t.FailNow()
+	}
+}
+
+func TestUpgradeReportsFailedRollback(t *testing.T) {
+
+	defer useTempHome(t)()
+	defer func(version string) { Version = version }(Version)
+	Version = "1.1.0"
+
+	cfg, fake := upgradeTestConfig(t)
+	fake.failures["ContainerExecCreate"] = errors.New("injected failure")
+	fake.failures["ContainerRename old kdk"] = errors.New("injected failure")
+	if result, err := Upgrade(cfg, UpgradeOptions{Version: "1.1.0"}); err == nil || result.RolledBack {
+		t.Logf("Upgrade with a failed rollback returned %+v, %v; expected it not rolled back.", result, err)
+		t.FailNow()
+	}
+	if c := fake.find("kdk-pre-upgrade"); c == nil || c.state != "exited" {
+		t.Logf("Previous container is %+v, expected it kept as kdk-pre-upgrade.", c)
+		t.FailNow()
+	}
+}
+
+func TestUpgradeRollbackRestoresBinary(t *testing.T) {
+
+	defer useTempHome(t)()
+	cfg, _ := fakeKdkEnvConfig(t)
+
+	// The binary replaced by the upgrade was archived under the running version
+	if err := os.MkdirAll(cfg.BinDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(cfg.ArchivedBinaryPath(Version), []byte("previous kdk"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	binFile := filepath.Join(cfg.Home(), "kdk")
+	if err := ioutil.WriteFile(binFile, []byte("upgraded kdk"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	progress := upgradeProgress{previous: cfg.ConfigFile, binaryReplaced: binFile}
+	cfg.ConfigFile.AppConfig.ImageTag = "1.1.0"
+	if err := progress.rollback(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := ioutil.ReadFile(binFile); string(content) != "previous kdk" {
+		t.Logf("Binary is %q after rollback, expected the previous binary.", content)
+		t.FailNow()
+	}
+	if cfg.ConfigFile.AppConfig.ImageTag != "1.0.0" {
+		t.Logf("Config is at %s after rollback, expected 1.0.0.", cfg.ConfigFile.AppConfig.ImageTag)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kdk
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Returns the KDK_UID, KDK_GID and KDK_DOCKER_GID environment of the host
+// user, with which provisioning creates the KDK user so that files in bind
+// mounts keep their host ownership.  Only Linux engines share numeric IDs with
+// bind mounts; Docker Desktop and remote engines get no IDs.
+func hostUserIDEnv(cfg KdkEnvConfig) []string {
+	if runtime.GOOS != "linux" || cfg.IsRemoteEngine() {
+		return nil
+	}
+	uid, gid := os.Getuid(), os.Getgid()
+	if uid <= 0 {
+		return nil
+	}
+	env := []string{"KDK_UID=" + strconv.Itoa(uid), "KDK_GID=" + strconv.Itoa(gid)}
+	if dockerGroup, err := user.LookupGroup("docker"); err == nil {
+		env = append(env, "KDK_DOCKER_GID="+dockerGroup.Gid)
+	}
+	return env
+}
+
+// Warns when the KDK user of the container doesn't have the IDs it was
+// created for, which happens with containers created before IDs were mapped
+// or when the user already existed in the image.
+func warnIfUserIDsMismatch(cfg KdkEnvConfig) {
+	containerJSON, err := cfg.DockerClient.ContainerInspect(cfg.Ctx, cfg.ConfigFile.AppConfig.Name)
+	if err != nil || containerJSON.Config == nil {
+		return
+	}
+	expected := map[string]string{}
+	for _, variable := range containerJSON.Config.Env {
+		keyValue := strings.SplitN(variable, "=", 2)
+		if len(keyValue) == 2 && (keyValue[0] == "KDK_UID" || keyValue[0] == "KDK_GID") {
+			expected[keyValue[0]] = keyValue[1]
+		}
+	}
+	hostIDs := map[string]string{}
+	for _, variable := range hostUserIDEnv(cfg) {
+		keyValue := strings.SplitN(variable, "=", 2)
+		hostIDs[keyValue[0]] = keyValue[1]
+	}
+	if len(hostIDs) == 0 {
+		return
+	}
+
+	var out bytes.Buffer
+	exitCode, err := containerExec(cfg, "", []string{"sh", "-c", "echo $(id -u " + cfg.User() + ") $(id -g " + cfg.User() + ")"}, &out, ioutil.Discard)
+	if err != nil || exitCode != 0 {
+		return
+	}
+	ids := strings.Fields(out.String())
+	if len(ids) != 2 {
+		return
+	}
+	if ids[0] == hostIDs["KDK_UID"] && ids[1] == hostIDs["KDK_GID"] {
+		return
+	}
+
+	logger := log.WithFields(log.Fields{"containerUID": ids[0], "containerGID": ids[1], "hostUID": hostIDs["KDK_UID"], "hostGID": hostIDs["KDK_GID"]})
+	if expected["KDK_UID"] == "" {
+		logger.Warn("KDK user IDs don't match the host user, so files created in bind mounts get the wrong owner.  " +
+			"The container predates user ID mapping; recreate it with `kdk destroy` and `kdk up`.")
+	} else {
+		logger.Warn("KDK user IDs don't match the host user, so files created in bind mounts get the wrong owner.  " +
+			"The user likely already existed in the image; see the provisioning log.")
+	}
+}
@@ -15,16 +15,16 @@
 package keybase
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"os/user"
 	"path/filepath"
-	"runtime"
 	"strings"
 
-	"github.com/cisco-sso/kdk/pkg/prompt"
-	"github.com/codeskyblue/go-sh"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -77,88 +77,97 @@ func writeMirrorScript(configDir string) (out string, err error) {
 	return scriptPath, nil
 }
 
-// Start keybase mirror [windows only]
-func StartMirror(configDir string) error {
-
-	keybaseTestDir := filepath.Join(configDir, "keybase", "private")
+// Runs the keybase CLI, returning its standard output
+var keybaseCLI = func(args ...string) ([]byte, error) {
+	if _, err := exec.LookPath("keybase"); err != nil {
+		return nil, err
+	}
+	return exec.Command("keybase", args...).Output()
+}
 
-	// keybase mirror already started.  Nothing to do
-	if _, err := os.Stat(keybaseTestDir); err == nil {
-		log.Info("Keybase mirror already started")
-		return nil
+// Detect the keybase filesystem root.  The mount directory is taken from
+// $KEYBASE_MOUNTDIR or the keybase CLI, which know per-user custom mount
+// locations, falling back to the default locations:
+// Linux & OSX: Detect /keybase, /run/user/<uid>/keybase/kbfs and /Volumes/Keybase
+// Windows10: Detect k: and /k
+func Detect() (string, error) {
+	if mountDir := os.Getenv("KEYBASE_MOUNTDIR"); mountDir != "" {
+		return keybaseRoot(mountDir)
+	}
+	if mountDir, err := cliMountDir(); err == nil {
+		if root, err := keybaseRoot(mountDir); err == nil {
+			return root, nil
+		}
+		log.WithField("mountdir", mountDir).Debug("Keybase CLI mount directory is not mounted")
+	} else {
+		log.WithField("error", err).Debug("Failed to query keybase CLI for its mount directory")
 	}
+	for _, mountDir := range defaultMountDirs() {
+		if root, err := keybaseRoot(mountDir); err == nil {
+			return root, nil
+		}
+	}
+	return "", errors.New("Failed to detect potential keybase filesystem mounts")
+}
 
-	// Write/Overwrite the mirror script every time
-	//   in case it changes up upgrades
-	log.Info("Writing keybase mirror script")
-	scriptPath, err := writeMirrorScript(configDir)
+// Query the keybase CLI for the mount directory.  The config only holds
+// a custom mount directory, while the status reports the active mount.
+func cliMountDir() (string, error) {
+	if out, err := keybaseCLI("config", "get", "-d", "-b", "mountdir"); err == nil {
+		if mountDir := strings.Trim(strings.TrimSpace(string(out)), `"`); mountDir != "" && mountDir != "null" {
+			return mountDir, nil
+		}
+	}
+	out, err := keybaseCLI("status", "--json")
 	if err != nil {
-		return err
+		return "", err
 	}
+	var status struct {
+		KBFS struct {
+			Mount string
+		}
+	}
+	if err := json.Unmarshal(out, &status); err != nil {
+		return "", err
+	}
+	if status.KBFS.Mount == "" {
+		return "", errors.New("keybase status reports no KBFS mount")
+	}
+	return status.KBFS.Mount, nil
+}
 
-	commandString := fmt.Sprintf("powershell %s %s", scriptPath, "start")
-	log.Debugf("Starting Keybase mirror with command; %s", commandString)
-	commandMap := strings.Split(commandString, " ")
-	if err := sh.Command(commandMap[0], commandMap[1:]).SetStdin(os.Stdin).Run(); err != nil {
-		return err
+func defaultMountDirs() []string {
+	mountDirs := []string{"/keybase"}
+	if uid := os.Getuid(); uid >= 0 {
+		mountDirs = append(mountDirs, fmt.Sprintf("/run/user/%d/keybase/kbfs", uid))
 	}
-	return nil
+	mountDirs = append(mountDirs, "/Volumes/keybase")
+	if u, err := user.Current(); err == nil {
+		mountDirs = append(mountDirs, fmt.Sprintf("/Volumes/Keybase (%s)", u.Username))
+	}
+	return append(mountDirs, "k:", "/k")
 }
 
-// Stop keybase mirror [windows only]
-func StopMirror(configDir string) error {
-	// TODO(rluckie) Fix StopMirror to work with multiple KDK containers
-	// Use docker client to iterate though all running containers and ensure that no containers have mirror dir mounted
-	// If so, stop mirror
-	log.Info("Writing keybase mirror script")
-	scriptPath, err := writeMirrorScript(configDir)
+// Returns the resolved keybase filesystem root mounted at mountDir
+func keybaseRoot(mountDir string) (string, error) {
+	absPath, err := filepath.Abs(filepath.Join(mountDir, "private"))
 	if err != nil {
-		return err
+		return "", err
 	}
-	commandString := fmt.Sprintf("powershell %s %s", scriptPath, "stop")
-	log.Debugf("Stopping Keybase mirror with command; %s", commandString)
-	commandMap := strings.Split(commandString, " ")
-	if err := sh.Command(commandMap[0], commandMap[1:]).SetStdin(os.Stdin).Run(); err != nil {
-		return err
+	path, err := filepath.EvalSymlinks(absPath)
+	if err != nil {
+		return "", err
 	}
-	return nil
+	return filepath.Dir(path), nil
 }
 
-// Get keybase mounts
-// Linux & OSX: Detect /keybase
-// Windows10: Detect k: and /k
-func GetMounts(configRootDir string) (source string, target string, err error) {
-
-	keybaseRoots := []string{"/keybase", "/Volumes/keybase", "k:", "/k"}
-	keybaseTestSubdir := "/private"
-	for _, keybaseRoot := range keybaseRoots {
-		if absPath, err := filepath.Abs(filepath.Join(keybaseRoot, keybaseTestSubdir)); err == nil {
-			if path, err := filepath.EvalSymlinks(absPath); err == nil {
-				source := filepath.Dir(path)
-				target := "/keybase"
-
-				log.Infof("Detected keybase filesystem at: %v", source)
-
-				prmpt := prompt.Prompt{
-					Text:     "Mount your keybase directory within KDK? [y/n] ",
-					Loop:     true,
-					Validate: prompt.ValidateYorN,
-				}
-				if result, err := prmpt.Run(); err == nil && result == "y" {
-					log.Info("Adding /keybase mount to configuration")
-					if runtime.GOOS == "windows" {
-						source = filepath.Join(configRootDir, "keybase")
-						if _, err := os.Stat(source); os.IsNotExist(err) {
-							if err := os.Mkdir(source, 0700); err != nil {
-								log.WithField("error", err).Fatalf("Failed to create KDK keybase mirror directory [%s]", source)
-								return "", "", err
-							}
-						}
-					}
-					return source, target, nil
-				}
-			}
+// Directory into which the keybase filesystem is mirrored for mounting [windows only]
+func MirrorDir(configRootDir string) (string, error) {
+	source := filepath.Join(configRootDir, "keybase")
+	if _, err := os.Stat(source); os.IsNotExist(err) {
+		if err := os.Mkdir(source, 0700); err != nil {
+			return "", fmt.Errorf("failed to create KDK keybase mirror directory [%s]: %v", source, err)
 		}
 	}
-	return "", "", errors.New("Failed to detect potential keybase filesystem mounts")
+	return source, nil
 }
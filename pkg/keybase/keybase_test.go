@@ -0,0 +1,73 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keybase
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetectQueriesKeybaseCLI(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "kdk-keybase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, _ = filepath.EvalSymlinks(dir)
+	mountDir := filepath.Join(dir, "kbfs")
+	if err := os.MkdirAll(filepath.Join(mountDir, "private"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("KEYBASE_MOUNTDIR", os.Getenv("KEYBASE_MOUNTDIR"))
+	os.Unsetenv("KEYBASE_MOUNTDIR")
+	defer func(cli func(args ...string) ([]byte, error)) { keybaseCLI = cli }(keybaseCLI)
+
+	// Without a custom mount directory configured, the status reports the mount
+	var calls []string
+	keybaseCLI = func(args ...string) ([]byte, error) {
+		calls = append(calls, strings.Join(args, " "))
+		if args[0] == "config" {
+			return []byte("\n"), nil
+		}
+		return []byte(`{"KBFS":{"Running":true,"Mount":"` + filepath.ToSlash(mountDir) + `"}}`), nil
+	}
+	if root, err := Detect(); err != nil || root != mountDir {
+		t.Logf("Detected %s (%v) through %v, expected %s.", root, err, calls, mountDir)
+		t.FailNow()
+	}
+
+	keybaseCLI = func(args ...string) ([]byte, error) {
+		if args[0] == "config" {
+			return []byte(mountDir + "\n"), nil
+		}
+		return nil, errors.New("status should not be queried")
+	}
+	if root, err := Detect(); err != nil || root != mountDir {
+		t.Logf("Detected %s (%v) from the configured mountdir, expected %s.", root, err, mountDir)
+		t.FailNow()
+	}
+
+	// $KEYBASE_MOUNTDIR overrides the CLI
+	os.Setenv("KEYBASE_MOUNTDIR", dir)
+	if _, err := Detect(); err == nil {
+		t.Logf("Detected keybase in %s, which has no private directory.", dir)
+		t.FailNow()
+	}
+}
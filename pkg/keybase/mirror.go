@@ -0,0 +1,115 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keybase
+
+import (
+	"os"
+	"strings"
+
+	"github.com/codeskyblue/go-sh"
+	log "github.com/sirupsen/logrus"
+)
+
+// State of the keybase mirror
+type MirrorState struct {
+	Running    bool     `json:"running"`
+	Containers []string `json:"containers"` // running KDK containers that mount the mirror
+}
+
+// Process mirroring the keybase filesystem
+type mirrorProcess interface {
+	Running() (bool, error)
+	Start() error
+	Stop() error
+}
+
+// Keybase mirror shared by every KDK that mounts it [windows only].  It is
+// started by the first KDK up and stopped once no KDK container mounts it.
+type Mirror struct {
+	process mirrorProcess
+}
+
+func NewMirror(configDir string) *Mirror {
+	return &Mirror{process: &scriptProcess{configDir: configDir}}
+}
+
+// Starts the mirror unless it is already running
+func (m *Mirror) Acquire() error {
+	running, err := m.process.Running()
+	if err != nil {
+		return err
+	}
+	if running {
+		log.Info("Keybase mirror already started")
+		return nil
+	}
+	log.Info("Starting keybase mirror")
+	return m.process.Start()
+}
+
+// Stops the mirror unless containers still mount it
+func (m *Mirror) Release(containers []string) error {
+	if len(containers) > 0 {
+		log.WithField("containers", strings.Join(containers, ",")).Info("Keybase mirror still in use")
+		return nil
+	}
+	running, err := m.process.Running()
+	if err != nil || !running {
+		return err
+	}
+	log.Info("Stopping keybase mirror")
+	return m.process.Stop()
+}
+
+// Returns the state of the mirror, given the containers that mount it
+func (m *Mirror) State(containers []string) (MirrorState, error) {
+	running, err := m.process.Running()
+	if containers == nil {
+		containers = []string{}
+	}
+	return MirrorState{Running: running, Containers: containers}, err
+}
+
+// Runs the mirror through the keybase mirror script
+type scriptProcess struct {
+	configDir string
+}
+
+func (p *scriptProcess) Running() (bool, error) {
+	out, err := sh.Command("tasklist", "/FI", "IMAGENAME eq mirror.exe", "/NH").Output()
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(strings.ToLower(string(out)), "mirror.exe"), nil
+}
+
+func (p *scriptProcess) Start() error {
+	return p.run("start")
+}
+
+func (p *scriptProcess) Stop() error {
+	return p.run("stop")
+}
+
+func (p *scriptProcess) run(action string) error {
+	// Write/Overwrite the mirror script every time
+	//   in case it changes on upgrades
+	scriptPath, err := writeMirrorScript(p.configDir)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Running keybase mirror script: powershell %s %s", scriptPath, action)
+	return sh.Command("powershell", scriptPath, action).SetStdin(os.Stdin).Run()
+}
@@ -0,0 +1,93 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keybase
+
+import (
+	"reflect"
+	"testing"
+)
+
+type fakeProcess struct {
+	running bool
+	starts  int
+	stops   int
+}
+
+func (p *fakeProcess) Running() (bool, error) {
+	return p.running, nil
+}
+
+func (p *fakeProcess) Start() error {
+	p.running = true
+	p.starts++
+	return nil
+}
+
+func (p *fakeProcess) Stop() error {
+	p.running = false
+	p.stops++
+	return nil
+}
+
+func TestMirrorStopsAfterLastContainer(t *testing.T) {
+
+	process := &fakeProcess{}
+	mirror := &Mirror{process: process}
+
+	// Two KDKs come up; only the first starts the mirror
+	for i := 0; i < 2; i++ {
+		if err := mirror.Acquire(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if process.starts != 1 {
+		t.Logf("Mirror started %d times, expected once.", process.starts)
+		t.FailNow()
+	}
+
+	// The first KDK is destroyed while the second still mounts the mirror
+	if err := mirror.Release([]string{"kdk2"}); err != nil {
+		t.Fatal(err)
+	}
+	state, err := mirror.State([]string{"kdk2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(state, MirrorState{Running: true, Containers: []string{"kdk2"}}) || process.stops != 0 {
+		t.Logf("Mirror state is %+v after %d stops, expected it running for kdk2.", state, process.stops)
+		t.FailNow()
+	}
+
+	// The last KDK is destroyed
+	if err := mirror.Release(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mirror.Release(nil); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := mirror.State(nil); state.Running || process.stops != 1 {
+		t.Logf("Mirror state is %+v after %d stops, expected one stop.", state, process.stops)
+		t.FailNow()
+	}
+
+	// A mirror that exited on its own is restarted by the next KDK up
+	if err := mirror.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if !process.running || process.starts != 2 {
+		t.Log("Mirror was not restarted.")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package minisign signs and verifies minisign
+// (https://jedisct1.github.io/minisign/) signatures, with which KDK releases
+// are signed.
+package minisign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	trustedCommentPrefix = "trusted comment: "
+
+	// Signature algorithms: Ed25519 over the message, or over its BLAKE2b-512 hash
+	algorithmLegacy    = "Ed"
+	algorithmPrehashed = "ED"
+)
+
+type PublicKey struct {
+	KeyID [8]byte
+	Key   ed25519.PublicKey
+}
+
+type Signature struct {
+	Algorithm       string
+	KeyID           [8]byte
+	Signature       []byte
+	TrustedComment  string
+	GlobalSignature []byte
+}
+
+// Parses a public key from its base64 encoding, or from the contents of a
+// minisign .pub file
+func ParsePublicKey(encoded string) (PublicKey, error) {
+	var publicKey PublicKey
+	lines := nonEmptyLines(encoded)
+	if len(lines) == 0 {
+		return publicKey, errors.New("empty minisign public key")
+	}
+	data, err := base64.StdEncoding.DecodeString(lines[len(lines)-1])
+	if err != nil {
+		return publicKey, fmt.Errorf("invalid minisign public key: %v", err)
+	}
+	if len(data) != 2+8+ed25519.PublicKeySize || string(data[:2]) != algorithmLegacy {
+		return publicKey, errors.New("invalid minisign public key: not an Ed25519 key")
+	}
+	copy(publicKey.KeyID[:], data[2:10])
+	publicKey.Key = ed25519.PublicKey(data[10:])
+	return publicKey, nil
+}
+
+// Parses the contents of a minisign .minisig file
+func ParseSignature(data []byte) (Signature, error) {
+	var signature Signature
+	lines := nonEmptyLines(string(data))
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], trustedCommentPrefix) {
+		return signature, errors.New("invalid minisign signature: unexpected format")
+	}
+	sig, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return signature, errors.New("invalid minisign signature: malformed signature line")
+	}
+	globalSig, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(globalSig) != ed25519.SignatureSize {
+		return signature, errors.New("invalid minisign signature: malformed global signature line")
+	}
+	signature.Algorithm = string(sig[:2])
+	copy(signature.KeyID[:], sig[2:10])
+	signature.Signature = sig[10:]
+	signature.TrustedComment = strings.TrimPrefix(lines[2], trustedCommentPrefix)
+	signature.GlobalSignature = globalSig
+	return signature, nil
+}
+
+// Verifies that the signature of message was made with the key, including the trusted comment
+func (p PublicKey) Verify(message []byte, signature Signature) error {
+	if !bytes.Equal(p.KeyID[:], signature.KeyID[:]) {
+		return fmt.Errorf("signature key ID %X does not match public key ID %X", signature.KeyID, p.KeyID)
+	}
+	switch signature.Algorithm {
+	case algorithmLegacy:
+	case algorithmPrehashed:
+		hash := blake2b.Sum512(message)
+		message = hash[:]
+	default:
+		return fmt.Errorf("unsupported signature algorithm [%s]", signature.Algorithm)
+	}
+	if !ed25519.Verify(p.Key, message, signature.Signature) {
+		return errors.New("invalid signature")
+	}
+	if !ed25519.Verify(p.Key, append(append([]byte{}, signature.Signature...), signature.TrustedComment...), signature.GlobalSignature) {
+		return errors.New("invalid signature of trusted comment")
+	}
+	return nil
+}
+
+// Returns the base64 encoding of a public key, as found in minisign .pub files
+func EncodePublicKey(keyID [8]byte, key ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(append(append([]byte(algorithmLegacy), keyID[:]...), key...))
+}
+
+// Returns the contents of a minisign .minisig file with a prehashed signature of message
+func Sign(privateKey ed25519.PrivateKey, keyID [8]byte, message []byte, trustedComment string) []byte {
+	hash := blake2b.Sum512(message)
+	sig := ed25519.Sign(privateKey, hash[:])
+	globalSig := ed25519.Sign(privateKey, append(append([]byte{}, sig...), trustedComment...))
+	return []byte("untrusted comment: signature from kdk\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte(algorithmPrehashed), keyID[:]...), sig...)) + "\n" +
+		trustedCommentPrefix + trustedComment + "\n" +
+		base64.StdEncoding.EncodeToString(globalSig) + "\n")
+}
+
+func nonEmptyLines(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
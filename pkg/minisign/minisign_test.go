@@ -0,0 +1,53 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package minisign
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+)
+
+func TestSignAndVerify(t *testing.T) {
+
+	key, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID := [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
+	publicKey, err := ParsePublicKey("untrusted comment: minisign public key\n" + EncodePublicKey(keyID, key) + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature, err := ParseSignature(Sign(privateKey, keyID, []byte("checksums"), "kdk release"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := publicKey.Verify([]byte("checksums"), signature); err != nil {
+		t.Logf("Signature failed to verify: %v", err)
+		t.FailNow()
+	}
+	if err := publicKey.Verify([]byte("tampered"), signature); err == nil {
+		t.Log("Signature of another message verified.")
+		t.FailNow()
+	}
+
+	signature.TrustedComment = "forged"
+	if err := publicKey.Verify([]byte("checksums"), signature); err == nil {
+		t.Log("Signature with a forged trusted comment verified.")
+		t.FailNow()
+	}
+}
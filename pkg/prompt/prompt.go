@@ -15,10 +15,33 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 )
 
+// Writer for prompts and spinners.  Set to os.Stderr when stdout carries
+// machine-readable command results.
+var Output io.Writer = os.Stdout
+
+// Input from which answers are read
+var Input io.Reader = os.Stdin
+
+// Scanner shared by successive prompts, so that input read ahead by one
+// prompt (e.g. piped answers) is not lost to the next
+var (
+	scanner      *bufio.Scanner
+	scannerInput io.Reader
+)
+
+func inputScanner() *bufio.Scanner {
+	if scanner == nil || scannerInput != Input {
+		scanner = bufio.NewScanner(Input)
+		scannerInput = Input
+	}
+	return scanner
+}
+
 type Prompt struct {
 	Text     string
 	Loop     bool
@@ -27,14 +50,16 @@ type Prompt struct {
 
 func (sp *Prompt) Run() (string, error) {
 
-	scanner := bufio.NewScanner(os.Stdin)
+	scanner := inputScanner()
 
 	for {
 		// Print the description
-		fmt.Print(sp.Text)
+		fmt.Fprint(Output, sp.Text)
 
-		// Block and read the input
-		scanner.Scan()
+		// Block and read the input, giving up at the end of input
+		if !scanner.Scan() {
+			break
+		}
 		text := scanner.Text()
 
 		// If no validation function exists, return the text immediately
@@ -47,7 +72,7 @@ func (sp *Prompt) Run() (string, error) {
 			return text, nil
 		} else {
 			// If the function didn't validate, print why, and continue the loop
-			fmt.Println(err)
+			fmt.Fprintln(Output, err)
 		}
 
 		// If we are not looping, break after the first iteration
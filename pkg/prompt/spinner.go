@@ -0,0 +1,67 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prompt
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Spinner animates a status line on stdout while a long running task
+// executes.  It prints nothing when stdout is not a terminal.
+type Spinner struct {
+	Text string
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+func (s *Spinner) Start() {
+	if file, ok := Output.(*os.File); !ok || !terminal.IsTerminal(int(file.Fd())) {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			fmt.Fprintf(Output, "\r%s %s", spinnerFrames[i%len(spinnerFrames)], s.Text)
+			select {
+			case <-s.stop:
+				// Clear the status line
+				fmt.Fprintf(Output, "\r%*s\r", len(s.Text)+2, "")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *Spinner) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	s.wg.Wait()
+	s.stop = nil
+}
@@ -0,0 +1,143 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/codeskyblue/go-sh"
+	log "github.com/sirupsen/logrus"
+)
+
+// Local directory encrypted with gocryptfs.  Its decrypted view is mounted on
+// the host (prompting for the password) and bind mounted into the KDK.
+type encryptedProvider struct {
+	cipherDir string
+	plainDir  string
+}
+
+func newEncryptedProvider(configRootDir string, cfg Config) *encryptedProvider {
+	p := &encryptedProvider{cipherDir: cfg.Cipher, plainDir: cfg.Source}
+	if p.cipherDir == "" {
+		p.cipherDir = filepath.Join(configRootDir, "secrets-encrypted")
+	}
+	if p.plainDir == "" {
+		p.plainDir = filepath.Join(configRootDir, "secrets")
+	}
+	return p
+}
+
+func (p *encryptedProvider) Detect() (string, error) {
+	if runtime.GOOS == "windows" {
+		return "", errors.New("gocryptfs is not available on windows")
+	}
+	if _, err := os.Stat(filepath.Join(p.cipherDir, "gocryptfs.conf")); err != nil {
+		return "", err
+	}
+	if _, err := exec.LookPath("gocryptfs"); err != nil {
+		return "", err
+	}
+	return p.plainDir, nil
+}
+
+func (p *encryptedProvider) Prompt(source string) string {
+	return "Mount your encrypted secrets directory within KDK? [y/n] "
+}
+
+func (p *encryptedProvider) Mount(source string) (Mount, error) {
+	return Mount{Source: source}, nil
+}
+
+// check if the decrypted view is a mount point, as listed by /proc/mounts on
+// linux or by mount(8) elsewhere
+func mounted(dir string) bool {
+	if runtime.GOOS == "windows" {
+		return false
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	// Mount tables list resolved paths, e.g. /private/var rather than /var on macOS
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	var table []byte
+	if runtime.GOOS == "linux" {
+		table, err = ioutil.ReadFile("/proc/mounts")
+	} else {
+		table, err = exec.Command("mount").Output()
+	}
+	if err != nil {
+		log.WithField("error", err).Debug("Failed to read mount table")
+		return false
+	}
+	return mountTableContains(string(table), dir)
+}
+
+// /proc/mounts escapes whitespace and backslashes in mount points as octal
+var mountPointUnescaper = strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+// check if a mount table lists dir as a mount point.  Mount points are the
+// second field of /proc/mounts lines and follow " on " in mount(8) lines.
+func mountTableContains(table, dir string) bool {
+	for _, line := range strings.Split(table, "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && mountPointUnescaper.Replace(fields[1]) == dir {
+			return true
+		}
+		if i := strings.Index(line, " on "); i >= 0 {
+			mountPoint := line[i+len(" on "):]
+			if j := strings.LastIndex(mountPoint, " ("); j >= 0 {
+				mountPoint = mountPoint[:j]
+			}
+			if mountPoint == dir {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *encryptedProvider) Start(source string) error {
+	if mounted(source) {
+		return nil
+	}
+	if err := os.MkdirAll(source, 0700); err != nil {
+		return err
+	}
+	log.Infof("Mounting encrypted secrets directory %s at %s", p.cipherDir, source)
+	return sh.Command("gocryptfs", p.cipherDir, source).SetStdin(os.Stdin).Run()
+}
+
+func (p *encryptedProvider) Stop(source string, containers []string) error {
+	if len(containers) > 0 || !mounted(source) {
+		return nil
+	}
+	unmount := []string{"fusermount", "-u", source}
+	if runtime.GOOS == "darwin" {
+		unmount = []string{"umount", source}
+	}
+	return sh.Command(unmount[0], unmount[1:]).Run()
+}
+
+func (p *encryptedProvider) Active(source string) (bool, error) {
+	return mounted(source), nil
+}
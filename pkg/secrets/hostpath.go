@@ -0,0 +1,59 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"errors"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// Any host directory, such as a team share or a directory synced by another tool
+type hostPathProvider struct {
+	source string
+}
+
+func (p *hostPathProvider) Detect() (string, error) {
+	if p.source == "" {
+		return "", errors.New("secrets provider [hostpath] requires Secrets.Source")
+	}
+	source, err := homedir.Expand(p.source)
+	if err != nil {
+		return "", err
+	}
+	return firstDir(source)
+}
+
+func (p *hostPathProvider) Prompt(source string) string {
+	return "Mount " + source + " within KDK? [y/n] "
+}
+
+func (p *hostPathProvider) Mount(source string) (Mount, error) {
+	source, err := homedir.Expand(source)
+	return Mount{Source: source}, err
+}
+
+func (p *hostPathProvider) Start(source string) error {
+	return nil
+}
+
+func (p *hostPathProvider) Stop(source string, containers []string) error {
+	return nil
+}
+
+func (p *hostPathProvider) Active(source string) (bool, error) {
+	source, err := homedir.Expand(source)
+	return isDir(source), err
+}
@@ -0,0 +1,68 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"runtime"
+
+	"github.com/cisco-sso/kdk/pkg/keybase"
+)
+
+// Keybase filesystem.  On Windows, where it cannot be bind mounted, it is
+// mirrored into ~/.kdk/keybase while a KDK runs.
+type keybaseProvider struct {
+	configRootDir string
+}
+
+func (p *keybaseProvider) Detect() (string, error) {
+	return keybase.Detect()
+}
+
+func (p *keybaseProvider) Prompt(source string) string {
+	return "Mount your keybase directory (" + source + ") within KDK? [y/n] "
+}
+
+func (p *keybaseProvider) Mount(source string) (Mount, error) {
+	if runtime.GOOS == "windows" {
+		mirrorDir, err := keybase.MirrorDir(p.configRootDir)
+		if err != nil {
+			return Mount{}, err
+		}
+		source = mirrorDir
+	}
+	return Mount{Source: source}, nil
+}
+
+func (p *keybaseProvider) Start(source string) error {
+	if runtime.GOOS != "windows" {
+		return nil
+	}
+	return keybase.NewMirror(p.configRootDir).Acquire()
+}
+
+func (p *keybaseProvider) Stop(source string, containers []string) error {
+	if runtime.GOOS != "windows" {
+		return nil
+	}
+	return keybase.NewMirror(p.configRootDir).Release(containers)
+}
+
+func (p *keybaseProvider) Active(source string) (bool, error) {
+	if runtime.GOOS != "windows" {
+		return isDir(source), nil
+	}
+	state, err := keybase.NewMirror(p.configRootDir).State(nil)
+	return state.Running, err
+}
@@ -0,0 +1,65 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// pass or gopass password store.  Its entries stay encrypted; decrypt them in
+// the KDK with the forwarded gpg agent or an imported key.
+type passProvider struct{}
+
+func (p *passProvider) Detect() (string, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	store, err := firstDir(
+		os.Getenv("PASSWORD_STORE_DIR"),
+		filepath.Join(home, ".password-store"),
+		filepath.Join(home, ".local", "share", "gopass", "stores", "root"),
+	)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(store, ".gpg-id")); err != nil {
+		return "", err
+	}
+	return store, nil
+}
+
+func (p *passProvider) Prompt(source string) string {
+	return "Mount your password store within KDK? [y/n] "
+}
+
+func (p *passProvider) Mount(source string) (Mount, error) {
+	return Mount{Source: source}, nil
+}
+
+func (p *passProvider) Start(source string) error {
+	return nil
+}
+
+func (p *passProvider) Stop(source string, containers []string) error {
+	return nil
+}
+
+func (p *passProvider) Active(source string) (bool, error) {
+	return isDir(source), nil
+}
@@ -0,0 +1,258 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package secrets mounts a secrets filesystem from the host into the KDK.
+// Providers detect the filesystem on the host, describe how it is mounted,
+// and make it available while a KDK uses it.
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cisco-sso/kdk/pkg/prompt"
+	log "github.com/sirupsen/logrus"
+)
+
+// Secrets providers
+const (
+	ProviderKeybase   = "keybase"
+	ProviderEncrypted = "encrypted"
+	ProviderPass      = "pass"
+	ProviderHostPath  = "hostpath"
+	ProviderNone      = "none"
+)
+
+var Providers = []string{ProviderKeybase, ProviderEncrypted, ProviderPass, ProviderHostPath, ProviderNone}
+
+// Providers detected without configuration, in order of preference
+var detectedProviders = []string{ProviderKeybase, ProviderEncrypted, ProviderPass}
+
+// Container path of secrets filesystems other than keybase
+const DefaultTarget = "/secrets"
+
+// Secrets filesystem mounted into the KDK.  Only Provider is required.
+type Config struct {
+	Provider string   // keybase | encrypted | pass | hostpath | none
+	Source   string   `json:",omitempty"` // host path; detected when unset
+	Target   string   `json:",omitempty"` // container path; defaults to /keybase for keybase, otherwise /secrets
+	ReadOnly bool     `json:",omitempty"`
+	Cipher   string   `json:",omitempty"` // encrypted: gocryptfs cipher directory; defaults to ~/.kdk/secrets-encrypted
+	Subpaths []string `json:",omitempty"` // mount only these subpaths (e.g. team/<team>), read-only
+}
+
+// Bind mount of a secrets filesystem into the KDK
+type Mount struct {
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+// A kind of secrets filesystem
+type Provider interface {
+	// Detects the secrets filesystem on the host, returning its path
+	Detect() (string, error)
+	// Question asked before mounting a detected secrets filesystem
+	Prompt(source string) string
+	// Returns the mount of the secrets filesystem at source
+	Mount(source string) (Mount, error)
+	// Makes the secrets filesystem available on the host before a KDK starts
+	Start(source string) error
+	// Releases the secrets filesystem unless the named containers still mount it
+	Stop(source string, containers []string) error
+	// Reports whether the secrets filesystem is available on the host
+	Active(source string) (bool, error)
+}
+
+// Returns the provider of a secrets config
+func New(configRootDir string, cfg Config) (Provider, error) {
+	switch cfg.Provider {
+	case ProviderKeybase:
+		return &keybaseProvider{configRootDir: configRootDir}, nil
+	case ProviderEncrypted:
+		return newEncryptedProvider(configRootDir, cfg), nil
+	case ProviderPass:
+		return &passProvider{}, nil
+	case ProviderHostPath:
+		return &hostPathProvider{source: cfg.Source}, nil
+	}
+	return nil, fmt.Errorf("unknown secrets provider [%s]; expected one of %s", cfg.Provider, strings.Join(Providers, "|"))
+}
+
+// Container path at which the secrets filesystem is mounted
+func (cfg Config) MountTarget() string {
+	if cfg.Target != "" {
+		return cfg.Target
+	}
+	if cfg.Provider == ProviderKeybase {
+		return "/keybase"
+	}
+	return DefaultTarget
+}
+
+// Resolves the secrets filesystem to mount into a new KDK.  A configured
+// provider is used as is; otherwise the first detected secrets filesystem is
+// offered.  Returns the config to persist and the mounts, or nil for neither.
+func Configure(configRootDir string, cfg *Config) (*Config, []Mount, error) {
+	if cfg != nil && cfg.Provider == ProviderNone {
+		return cfg, nil, nil
+	}
+	if cfg != nil && cfg.Provider != "" {
+		resolved := *cfg
+		provider, err := New(configRootDir, resolved)
+		if err != nil {
+			return nil, nil, err
+		}
+		if resolved.Source == "" {
+			if resolved.Source, err = provider.Detect(); err != nil {
+				return nil, nil, err
+			}
+		}
+		mounts, err := mountsFor(provider, resolved)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &resolved, mounts, nil
+	}
+
+	for _, name := range detectedProviders {
+		resolved := Config{Provider: name}
+		if cfg != nil {
+			resolved = *cfg
+			resolved.Provider = name
+		}
+		provider, _ := New(configRootDir, resolved)
+		source, err := provider.Detect()
+		if err != nil {
+			log.WithFields(log.Fields{"provider": name, "error": err}).Debug("No secrets filesystem detected")
+			continue
+		}
+		log.Infof("Detected %s secrets filesystem at: %v", name, source)
+		prmpt := prompt.Prompt{
+			Text:     provider.Prompt(source),
+			Loop:     true,
+			Validate: prompt.ValidateYorN,
+		}
+		if result, err := prmpt.Run(); err != nil || result == "n" {
+			continue
+		}
+		resolved.Source = source
+		mounts, err := mountsFor(provider, resolved)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Infof("Adding %s mount to configuration", resolved.MountTarget())
+		return &resolved, mounts, nil
+	}
+	return nil, nil, errors.New("Failed to detect a secrets filesystem")
+}
+
+func mountsFor(provider Provider, cfg Config) ([]Mount, error) {
+	root, err := provider.Mount(cfg.Source)
+	if err != nil {
+		return nil, err
+	}
+	root.Target = cfg.MountTarget()
+	root.ReadOnly = root.ReadOnly || cfg.ReadOnly
+	if len(cfg.Subpaths) == 0 {
+		return []Mount{root}, nil
+	}
+	var mounts []Mount
+	for _, subpath := range cfg.Subpaths {
+		// Cleaned as an absolute path so that subpaths cannot escape the root
+		clean := path.Clean("/" + filepath.ToSlash(subpath))
+		if clean == "/" {
+			return nil, fmt.Errorf("invalid secrets subpath [%s]", subpath)
+		}
+		mounts = append(mounts, Mount{
+			Source:   filepath.Join(root.Source, filepath.FromSlash(clean)),
+			Target:   path.Join(root.Target, clean),
+			ReadOnly: true,
+		})
+	}
+	return mounts, nil
+}
+
+// Host path mounted for the configured secrets filesystem.  Subpath mounts lie below it.
+func MountSource(configRootDir string, cfg *Config) (string, error) {
+	if cfg == nil || cfg.Provider == "" || cfg.Provider == ProviderNone {
+		return "", nil
+	}
+	provider, err := New(configRootDir, *cfg)
+	if err != nil {
+		return "", err
+	}
+	root, err := provider.Mount(cfg.Source)
+	return root.Source, err
+}
+
+// Makes the configured secrets filesystem available before a KDK starts
+func Start(configRootDir string, cfg *Config) error {
+	if cfg == nil || cfg.Provider == "" || cfg.Provider == ProviderNone {
+		return nil
+	}
+	provider, err := New(configRootDir, *cfg)
+	if err != nil {
+		return err
+	}
+	return provider.Start(cfg.Source)
+}
+
+// Releases the configured secrets filesystem unless the named running
+// containers still mount it
+func Stop(configRootDir string, cfg *Config, containers []string) error {
+	if cfg == nil || cfg.Provider == "" || cfg.Provider == ProviderNone {
+		return nil
+	}
+	provider, err := New(configRootDir, *cfg)
+	if err != nil {
+		return err
+	}
+	return provider.Stop(cfg.Source, containers)
+}
+
+// Reports whether the configured secrets filesystem is available on the host
+func Active(configRootDir string, cfg *Config) (bool, error) {
+	if cfg == nil || cfg.Provider == "" || cfg.Provider == ProviderNone {
+		return false, nil
+	}
+	provider, err := New(configRootDir, *cfg)
+	if err != nil {
+		return false, err
+	}
+	return provider.Active(cfg.Source)
+}
+
+// check if path is an existing directory
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// Returns the first of the paths that is an existing directory
+func firstDir(paths ...string) (string, error) {
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if isDir(path) {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("none of %s exists", strings.Join(paths, ", "))
+}
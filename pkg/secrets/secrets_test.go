@@ -0,0 +1,147 @@
+// Copyright © 2018 Cisco Systems, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package secrets
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestConfigureExplicitProviders(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "kdk-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg, mounts, err := Configure(dir, &Config{Provider: ProviderNone})
+	if err != nil || cfg == nil || cfg.Provider != ProviderNone || mounts != nil {
+		t.Logf("Provider none configured %+v with mounts %+v: %v", cfg, mounts, err)
+		t.FailNow()
+	}
+
+	cfg, mounts, err = Configure(dir, &Config{Provider: ProviderHostPath, Source: dir, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(mounts, []Mount{{Source: dir, Target: DefaultTarget, ReadOnly: true}}) || cfg.Source != dir {
+		t.Logf("Host path configured %+v with mounts %+v.", cfg, mounts)
+		t.FailNow()
+	}
+
+	if _, _, err := Configure(dir, &Config{Provider: ProviderHostPath}); err == nil {
+		t.Log("Host path without a source is accepted.")
+		t.FailNow()
+	}
+	if _, _, err := Configure(dir, &Config{Provider: "vault"}); err == nil {
+		t.Log("Unknown provider is accepted.")
+		t.FailNow()
+	}
+}
+
+func TestPassDetectsPasswordStoreDir(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "kdk-pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("PASSWORD_STORE_DIR", os.Getenv("PASSWORD_STORE_DIR"))
+	os.Setenv("PASSWORD_STORE_DIR", dir)
+
+	provider := &passProvider{}
+	if source, err := provider.Detect(); err == nil && source == dir {
+		t.Log("Password store without .gpg-id is detected.")
+		t.FailNow()
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ".gpg-id"), []byte("kdk@example.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, mounts, err := Configure(dir, &Config{Provider: ProviderPass, Target: "/home/kdk/.password-store"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Source != dir || len(mounts) != 1 || mounts[0].Source != dir || mounts[0].Target != "/home/kdk/.password-store" {
+		t.Logf("Pass configured %+v with mounts %+v, expected %s.", cfg, mounts, dir)
+		t.FailNow()
+	}
+}
+
+func TestSubpathsMountReadOnly(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "kdk-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, mounts, err := Configure(dir, &Config{Provider: ProviderKeybase, Source: dir, Subpaths: []string{"team/kdk", "/private/../public/kdk"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Mount{
+		{Source: filepath.Join(dir, "team", "kdk"), Target: "/keybase/team/kdk", ReadOnly: true},
+		{Source: filepath.Join(dir, "public", "kdk"), Target: "/keybase/public/kdk", ReadOnly: true},
+	}
+	if runtime.GOOS != "windows" && !reflect.DeepEqual(mounts, expected) {
+		t.Logf("Subpath mounts are %+v, expected %+v.", mounts, expected)
+		t.FailNow()
+	}
+
+	if _, _, err := Configure(dir, &Config{Provider: ProviderHostPath, Source: dir, Subpaths: []string{"../.."}}); err == nil {
+		t.Log("Subpath escaping the secrets filesystem is accepted.")
+		t.FailNow()
+	}
+}
+
+func TestMountTableContains(t *testing.T) {
+
+	procMounts := "sysfs /sys sysfs rw,nosuid 0 0\n" +
+		"/dev/fuse /home/me/.kdk/my\\040secrets fuse.gocryptfs rw,nosuid,nodev 0 0\n"
+	mountOutput := "/dev/disk1s1 on / (apfs, local, journaled)\n" +
+		"gocryptfs@/Users/me/.kdk/secrets-encrypted on /Users/me/.kdk/secrets (macfuse, nodev, nosuid)\n"
+
+	for _, tc := range []struct {
+		table, dir string
+		expected   bool
+	}{
+		{procMounts, "/home/me/.kdk/my secrets", true},
+		{procMounts, "/home/me/.kdk", false},
+		{mountOutput, "/Users/me/.kdk/secrets", true},
+		{mountOutput, "/Users/me/.kdk/secrets-encrypted", false},
+	} {
+		if actual := mountTableContains(tc.table, tc.dir); actual != tc.expected {
+			t.Logf("mountTableContains(%q) is %v, expected %v.", tc.dir, actual, tc.expected)
+			t.FailNow()
+		}
+	}
+
+	// A directory with files in it is not a mount point
+	dir, err := ioutil.TempDir("", "kdk-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "token"), []byte("secret"), 0600)
+	if mounted(dir) {
+		t.Logf("%s is reported as mounted.", dir)
+		t.FailNow()
+	}
+}
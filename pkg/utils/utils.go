@@ -15,8 +15,11 @@
 package utils
 
 import (
+	"bytes"
 	"net"
 	"reflect"
+	"strconv"
+	"strings"
 )
 
 func Contains(input interface{}, target interface{}) bool {
@@ -49,3 +52,52 @@ func GetPort() int {
 		}
 	}
 }
+
+// Check whether a TCP port is free to listen on all interfaces
+func PortAvailable(port int) bool {
+	listen, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return false
+	}
+	listen.Close()
+	return true
+}
+
+// TailWriter is an io.Writer that retains only the last Size lines written to it
+type TailWriter struct {
+	Size int
+
+	lines   []string
+	partial bytes.Buffer
+}
+
+func (t *TailWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b == '\n' {
+			t.push(t.partial.String())
+			t.partial.Reset()
+		} else {
+			t.partial.WriteByte(b)
+		}
+	}
+	return len(p), nil
+}
+
+func (t *TailWriter) push(line string) {
+	t.lines = append(t.lines, strings.TrimSuffix(line, "\r"))
+	if len(t.lines) > t.Size {
+		t.lines = t.lines[len(t.lines)-t.Size:]
+	}
+}
+
+// Lines returns the retained lines, including a trailing unterminated line
+func (t *TailWriter) Lines() []string {
+	lines := append([]string{}, t.lines...)
+	if t.partial.Len() > 0 {
+		lines = append(lines, t.partial.String())
+		if len(lines) > t.Size {
+			lines = lines[len(lines)-t.Size:]
+		}
+	}
+	return lines
+}
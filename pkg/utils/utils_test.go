@@ -15,6 +15,7 @@
 package utils
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -85,3 +86,16 @@ func TestSliceContainsCustom(t *testing.T) {
 	}
 
 }
+
+func TestTailWriter(t *testing.T) {
+
+	tail := TailWriter{Size: 2}
+	tail.Write([]byte("one\ntwo\nthr"))
+	tail.Write([]byte("ee\nfour"))
+
+	expected := []string{"three", "four"}
+	if result := tail.Lines(); !reflect.DeepEqual(result, expected) {
+		t.Logf("TailWriter retained %v, expected %v.", result, expected)
+		t.FailNow()
+	}
+}